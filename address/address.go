@@ -0,0 +1,212 @@
+package address
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//获取本服务对外的ip和端口，用于consul、prometheus注册
+type Provider interface {
+	Name() string
+	//不适用于当前环境或获取失败时返回err，然后尝试下一个
+	Address(ctx context.Context) (ip string, port int, err error)
+}
+
+var (
+	//默认依次尝试的provider，可以修改，例如追加 Interface{Port: 8080}
+	Providers = []Provider{Env{}, Ecs{}, Kubernetes{}}
+	//GetWithTimeout的默认超时
+	Timeout = 60 * time.Second
+)
+
+//依次尝试providers，返回第一个成功的，providers为空时使用Providers
+func Get(ctx context.Context, providers ...Provider) (ip string, port int, err error) {
+	if len(providers) == 0 {
+		providers = Providers
+	}
+	var errs []string
+	for _, provider := range providers {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		entry := logrus.WithField("provider", provider.Name())
+		if ip, port, err = provider.Address(ctx); err == nil {
+			entry.WithFields(logrus.Fields{
+				"ip":   ip,
+				"port": port,
+			}).Info("get address ok")
+			return
+		}
+		entry.WithError(err).Debug("get address failed")
+		errs = append(errs, fmt.Sprintf("%s: %s", provider.Name(), err))
+	}
+	err = fmt.Errorf("cant get address, %s", strings.Join(errs, "; "))
+	logrus.WithError(err).Error()
+	return "", 0, err
+}
+
+func GetWithTimeout() (ip string, port int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return Get(ctx)
+}
+
+//显式配置
+type Static struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+func (Static) Name() string {
+	return "static"
+}
+
+func (m Static) Address(ctx context.Context) (string, int, error) {
+	if m.IP == "" || m.Port == 0 {
+		return "", 0, fmt.Errorf("ip or port is empty")
+	}
+	return m.IP, m.Port, nil
+}
+
+//从环境变量读取，默认SERVICE_IP、SERVICE_PORT
+type Env struct {
+	IPEnv   string
+	PortEnv string
+}
+
+func (Env) Name() string {
+	return "env"
+}
+
+func (m Env) Address(ctx context.Context) (string, int, error) {
+	if m.IPEnv == "" {
+		m.IPEnv = "SERVICE_IP"
+	}
+	if m.PortEnv == "" {
+		m.PortEnv = "SERVICE_PORT"
+	}
+	return fromEnv(m.IPEnv, m.PortEnv, 0)
+}
+
+//kubernetes中用downward API把pod ip设置到环境变量，默认POD_IP，
+//端口从环境变量PortEnv（默认POD_PORT）读取，没有则用Port
+type Kubernetes struct {
+	IPEnv   string
+	PortEnv string
+	Port    int
+}
+
+func (Kubernetes) Name() string {
+	return "kubernetes"
+}
+
+func (m Kubernetes) Address(ctx context.Context) (string, int, error) {
+	if m.IPEnv == "" {
+		m.IPEnv = "POD_IP"
+	}
+	if m.PortEnv == "" {
+		m.PortEnv = "POD_PORT"
+	}
+	return fromEnv(m.IPEnv, m.PortEnv, m.Port)
+}
+
+func fromEnv(ipEnv, portEnv string, defaultPort int) (ip string, port int, err error) {
+	if ip = os.Getenv(ipEnv); ip == "" {
+		return "", 0, fmt.Errorf("env %s is empty", ipEnv)
+	}
+	port = defaultPort
+	if s := os.Getenv(portEnv); s != "" {
+		if port, err = strconv.Atoi(s); err != nil {
+			return "", 0, fmt.Errorf("env %s invalid: %s", portEnv, err)
+		}
+	}
+	if port == 0 {
+		return "", 0, fmt.Errorf("env %s is empty", portEnv)
+	}
+	return ip, port, nil
+}
+
+//第一个非回环的ipv4网卡地址，端口必须配置
+type Interface struct {
+	Port int
+}
+
+func (Interface) Name() string {
+	return "interface"
+}
+
+func (m Interface) Address(ctx context.Context) (string, int, error) {
+	if m.Port == 0 {
+		return "", 0, fmt.Errorf("port is empty")
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", 0, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), m.Port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no non-loopback ipv4 interface")
+}
+
+//aws ecs的元数据文件，容器刚启动时文件可能还没READY，每隔PollInterval（默认1s）重试直到ctx结束
+type Ecs struct {
+	PollInterval time.Duration
+}
+
+func (Ecs) Name() string {
+	return "ecs"
+}
+
+func (m Ecs) Address(ctx context.Context) (ip string, port int, err error) {
+	ecsMeta := os.Getenv("ECS_CONTAINER_METADATA_FILE")
+	if ecsMeta == "" {
+		return "", 0, fmt.Errorf("env ECS_CONTAINER_METADATA_FILE is empty")
+	}
+	if m.PollInterval == 0 {
+		m.PollInterval = time.Second
+	}
+	for {
+		if ip, port, err = readEcsMeta(ecsMeta); err == nil {
+			return
+		}
+		logrus.WithField("ecsMeta", ecsMeta).WithError(err).Debug("ecs meta not ready")
+		select {
+		case <-ctx.Done():
+			return "", 0, fmt.Errorf("%s, last err: %s", ctx.Err(), err)
+		case <-time.After(m.PollInterval):
+		}
+	}
+}
+
+func readEcsMeta(ecsMeta string) (ip string, port int, err error) {
+	ecsData, err := ioutil.ReadFile(ecsMeta)
+	if err != nil {
+		return
+	}
+	var metaData struct {
+		PortMappings []struct {
+			HostPort int `json:"HostPort"`
+		} `json:"PortMappings"`
+		HostPrivateIPv4Address string `json:"HostPrivateIPv4Address"`
+		MetadataFileStatus     string `json:"MetadataFileStatus"`
+	}
+	if err = json.Unmarshal(ecsData, &metaData); err != nil {
+		return
+	}
+	if metaData.MetadataFileStatus != "READY" || len(metaData.PortMappings) == 0 {
+		err = fmt.Errorf("hasn't ready")
+		return
+	}
+	return metaData.HostPrivateIPv4Address, metaData.PortMappings[0].HostPort, nil
+}
@@ -0,0 +1,58 @@
+package address
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	os.Setenv("SERVICE_IP", "")
+	os.Setenv("POD_IP", "10.0.0.1")
+	os.Setenv("POD_PORT", "")
+	defer os.Unsetenv("POD_IP")
+	ctx := context.Background()
+
+	ip, port, err := Get(ctx, Env{}, Kubernetes{Port: 8080}, Static{IP: "1.1.1.1", Port: 1})
+	if err != nil || ip != "10.0.0.1" || port != 8080 {
+		t.Errorf("get %s %d %v", ip, port, err)
+	}
+	if _, _, err = Get(ctx, Env{}, Kubernetes{}); err == nil {
+		t.Error("want err without port")
+	}
+	if _, port, err = Get(ctx, Interface{}, Interface{Port: 1}); err != nil && port != 1 {
+		t.Errorf("get %d %v", port, err)
+	}
+}
+
+func TestEcs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "meta.json")
+	ioutil.WriteFile(path, []byte(`{"MetadataFileStatus":"NOT_READY"}`), 0644)
+	os.Setenv("ECS_CONTAINER_METADATA_FILE", path)
+	defer os.Unsetenv("ECS_CONTAINER_METADATA_FILE")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err = Get(ctx, Ecs{PollInterval: 10 * time.Millisecond}); err == nil {
+		t.Error("want timeout")
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ioutil.WriteFile(path, []byte(`{"MetadataFileStatus":"READY","HostPrivateIPv4Address":"10.0.0.2","PortMappings":[{"HostPort":32768}]}`), 0644)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ip, port, err := Get(ctx, Ecs{PollInterval: 10 * time.Millisecond})
+	if err != nil || ip != "10.0.0.2" || port != 32768 {
+		t.Errorf("get %s %d %v", ip, port, err)
+	}
+}
@@ -0,0 +1,205 @@
+package configlint
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"zlutils/consul"
+)
+
+//一个consul key对应的配置类型
+type Entry struct {
+	Key      string
+	Value    interface{}   //配置的零值，用于确定类型
+	Consul   consul.Consul //与服务中读取时相同的校验、分层、默认值等，前缀和来源会被替换
+	Yaml     bool
+	Optional bool //export中没有该key时不报错
+}
+
+var registry = map[string]Entry{}
+
+//注册服务中用到的配置，一般在各配置的包中init时注册，
+//例如 configlint.Register("mysql", mysql.Config{}, consul.ValiStruct())
+func Register(key string, value interface{}, c consul.Consul) {
+	registry[key] = Entry{Key: key, Value: value, Consul: c}
+}
+
+func RegisterYaml(key string, value interface{}, c consul.Consul) {
+	registry[key] = Entry{Key: key, Value: value, Consul: c, Yaml: true}
+}
+
+func RegisterEntry(entry Entry) {
+	registry[entry.Key] = entry
+}
+
+func Entries() (entries []Entry) {
+	for _, entry := range registry {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return
+}
+
+//一个key的校验结果
+type Problem struct {
+	Key string `json:"key"`
+	Err string `json:"err"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Err)
+}
+
+//用与服务中相同的方式读取并校验，values的key是去掉prefix后的相对key，
+//只校验注册过的key（分层配置的各层等未注册的key只用于合并），checkMissing为true时values中缺少非Optional的key也算问题
+func Lint(prefix string, values map[string][]byte, checkMissing bool) (problems []Problem) {
+	src := consul.NewMapSource(nil)
+	for k, v := range values {
+		src.Set(fullKey(prefix, k), v)
+	}
+	for _, entry := range Entries() {
+		if _, ok := values[entry.Key]; !ok && (!checkMissing || entry.Optional) {
+			continue
+		}
+		lo := entry.Consul.WithSource(src).WithPrefix(prefix)
+		ptr := reflect.New(reflect.TypeOf(entry.Value)).Interface()
+		var err error
+		if entry.Yaml {
+			err = lo.LoadYaml(entry.Key, ptr)
+		} else {
+			err = lo.LoadJson(entry.Key, ptr)
+		}
+		if err != nil {
+			problems = append(problems, Problem{Key: entry.Key, Err: err.Error()})
+		}
+	}
+	return
+}
+
+func fullKey(prefix, key string) string {
+	return fmt.Sprintf("%s/%s", prefix, key)
+}
+
+//consul kv export的格式
+type exportPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"` //base64
+}
+
+//解析consul kv export的输出，返回去掉prefix后的相对key，不在prefix下的和目录被忽略
+func ParseExport(data []byte, prefix string) (map[string][]byte, error) {
+	var pairs []exportPair
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return nil, err
+	}
+	values := map[string][]byte{}
+	for _, pair := range pairs {
+		k := strings.TrimPrefix(pair.Key, strings.TrimSuffix(prefix, "/")+"/")
+		if k == pair.Key && prefix != "" || k == "" || strings.HasSuffix(k, "/") {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", pair.Key, err)
+		}
+		values[k] = value
+	}
+	return values, nil
+}
+
+type fileFlags map[string]string
+
+func (m fileFlags) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m fileFlags) Set(s string) error {
+	ss := strings.SplitN(s, "=", 2)
+	if len(ss) != 2 {
+		return fmt.Errorf("want key=path, get %s", s)
+	}
+	m[ss[0]] = ss[1]
+	return nil
+}
+
+//命令行入口，在服务中新建一个main包，import注册了配置的包后调用Main，例如
+//	go run ./cmd/configlint -prefix service/example -export kv.json
+//	go run ./cmd/configlint -file mysql=mysql.json -file log=log.yaml
+//	go run ./cmd/configlint -schema
+func Main() {
+	logrus.SetLevel(logrus.FatalLevel) //问题由Run输出，不需要consul包的日志
+	os.Exit(Run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+//返回退出码，0表示没有问题
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("configlint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	prefix := fs.String("prefix", "", "consul key prefix, same as consul.Init")
+	export := fs.String("export", "", "file of consul kv export output, all registered keys are required unless optional")
+	files := fileFlags{}
+	fs.Var(files, "file", "key=path, validate a json/yaml file as key, repeatable")
+	schema := fs.Bool("schema", false, "print json schema of registered keys")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *schema {
+		schemas := map[string]interface{}{}
+		for _, entry := range Entries() {
+			schemas[entry.Key] = Schema(entry.Value)
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(schemas)
+		return 0
+	}
+
+	values := map[string][]byte{}
+	if *export != "" {
+		data, err := ioutil.ReadFile(*export)
+		if err == nil {
+			values, err = ParseExport(data, *prefix)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "read export failed: %s\n", err)
+			return 2
+		}
+	}
+	for key, path := range files {
+		if _, ok := registry[key]; !ok {
+			fmt.Fprintf(stderr, "key %s not registered\n", key)
+			return 2
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "read file failed: %s\n", err)
+			return 2
+		}
+		values[key] = data
+	}
+	if len(values) == 0 && *export == "" {
+		fs.Usage()
+		return 2
+	}
+
+	problems := Lint(*prefix, values, *export != "")
+	for _, problem := range problems {
+		fmt.Fprintln(stdout, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Fprintf(stdout, "%d keys ok\n", len(values))
+	return 0
+}
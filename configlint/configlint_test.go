@@ -0,0 +1,118 @@
+package configlint
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"zlutils/consul"
+	zt "zlutils/time"
+)
+
+type tmpConfig struct {
+	Url     string       `json:"url" validate:"required"`
+	Conns   int          `json:"conns" validate:"min=1,max=100"`
+	Mode    string       `json:"mode" validate:"omitempty,oneof=a b"`
+	Timeout *zt.Duration `json:"timeout"`
+	Hosts   []string     `json:"hosts" validate:"min=1,dive,required"`
+}
+
+func init() {
+	Register("mysql", tmpConfig{}, consul.ValiStruct())
+	RegisterEntry(Entry{Key: "log", Value: tmpConfig{}, Consul: consul.ValiStruct(), Yaml: true, Optional: true})
+}
+
+func TestLint(t *testing.T) {
+	problems := Lint("p", map[string][]byte{
+		"mysql": []byte(`{"url":"u","conns":0,"hosts":["h"]}`),
+		"other": []byte(`not registered`),
+	}, true)
+	if len(problems) != 1 || problems[0].Key != "mysql" || !strings.Contains(problems[0].Err, "Conns") {
+		t.Errorf("get %v", problems)
+	}
+	if problems = Lint("p", map[string][]byte{"log": []byte("url: u\nconns: 1\nhosts: [h]")}, true); len(problems) != 1 || problems[0].Key != "mysql" {
+		t.Errorf("get %v want missing mysql", problems)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configlint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	export, _ := json.Marshal([]map[string]interface{}{
+		{"key": "service/example/", "flags": 0, "value": ""},
+		{"key": "service/example/mysql", "flags": 0, "value": base64.StdEncoding.EncodeToString([]byte(`{"url":"u","conns":1,"hosts":["h"],"timeout":"1s"}`))},
+		{"key": "service/other/mysql", "flags": 0, "value": base64.StdEncoding.EncodeToString([]byte(`{}`))},
+	})
+	exportPath := filepath.Join(dir, "kv.json")
+	ioutil.WriteFile(exportPath, export, 0644)
+	badPath := filepath.Join(dir, "bad.yaml")
+	ioutil.WriteFile(badPath, []byte("url: u\nconns: 1\nhosts: []"), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"-prefix", "service/example", "-export", exportPath}, &stdout, &stderr); code != 0 {
+		t.Errorf("get code %d %s %s", code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if code := Run([]string{"-file", "log=" + badPath}, &stdout, &stderr); code != 1 || !strings.Contains(stdout.String(), "log: ") {
+		t.Errorf("get code %d %s", code, stdout.String())
+	}
+	if code := Run([]string{"-file", "none=" + badPath}, &stdout, &stderr); code != 2 {
+		t.Errorf("get code %d", code)
+	}
+}
+
+type node struct {
+	Name     string  `json:"name" validate:"required"`
+	Children []*node `json:"children"`
+	Leaf     *leaf   `json:"leaf"`
+}
+
+type leaf struct {
+	Parent *node  `json:"parent"`
+	Self   *leaf  `json:"self"`
+	Size   int    `json:"size" validate:"omitempty,min=1"`
+	Tags   []int  `json:"tags" validate:"omitempty,required,len=2"`
+	Kind   string `json:"kind" validate:"omitempty,min=2,oneof=ab cd"`
+}
+
+func TestSchemaRecursive(t *testing.T) {
+	bs, _ := json.Marshal(Schema(node{}))
+	for _, want := range []string{
+		`"children":{"items":{"$ref":"#"},"type":"array"}`,
+		`"leaf":{"$ref":"#/definitions/leaf"}`,
+		`"parent":{"$ref":"#"}`,
+		`"self":{"$ref":"#/definitions/leaf"}`,
+		`"size":{"anyOf":[{"const":0},{"minimum":1}],"type":"integer"}`,
+		`"tags":{"anyOf":[{"maxItems":0},{"maxItems":2,"minItems":2}],"items":{"type":"integer"},"type":"array"}`,
+		`"kind":{"anyOf":[{"const":""},{"enum":["ab","cd"],"minLength":2}],"type":"string"}`,
+	} {
+		if !strings.Contains(string(bs), want) {
+			t.Errorf("get %s want %s", bs, want)
+		}
+	}
+	if strings.Contains(string(bs), `"required":["tags"]`) {
+		t.Errorf("get %s omitempty field required", bs)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	bs, _ := json.Marshal(Schema(tmpConfig{}))
+	for _, want := range []string{
+		`"required":["url"]`,
+		`"conns":{"maximum":100,"minimum":1,"type":"integer"}`,
+		`"mode":{"enum":["","a","b"],"type":"string"}`, //omitempty时零值也合法
+		`"timeout":{"type":"string"}`,
+		`"hosts":{"items":{"type":"string"},"minItems":1,"type":"array"}`,
+	} {
+		if !strings.Contains(string(bs), want) {
+			t.Errorf("get %s want %s", bs, want)
+		}
+	}
+}
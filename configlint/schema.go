@@ -0,0 +1,244 @@
+package configlint
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+//根据json tag和validate tag生成JSON Schema（draft-07），用于编辑器提示和其他语言的校验，
+//validate tag只转换了required、omitempty、min、max、len、gt、gte、lt、lte、oneof、dive，其他的只能由Lint校验，
+//递归的结构体放在definitions中用$ref引用
+func Schema(value interface{}) map[string]interface{} {
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	b := &schemaBuilder{
+		root:     t,
+		building: map[reflect.Type]bool{},
+		refs:     map[reflect.Type]string{},
+		names:    map[string]bool{},
+		defs:     map[string]interface{}{},
+	}
+	schema := b.typeSchema(t, "")
+	if len(b.defs) > 0 {
+		schema["definitions"] = b.defs
+	}
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	return schema
+}
+
+type schemaBuilder struct {
+	root     reflect.Type
+	building map[reflect.Type]bool   //正在生成的结构体，再次遇到时说明是递归类型
+	refs     map[reflect.Type]string //递归类型的$ref
+	names    map[string]bool         //definitions中已用的名字
+	defs     map[string]interface{}
+}
+
+func (b *schemaBuilder) typeSchema(t reflect.Type, validate string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	schema := map[string]interface{}{}
+	rules, dive := splitDive(validate)
+	switch {
+	case reflect.PtrTo(t).Implements(textUnmarshalerType): //例如zlutils/time.Duration
+		schema["type"] = "string"
+	case reflect.PtrTo(t).Implements(jsonUnmarshalerType): //无法得知格式
+		return schema
+	default:
+		switch t.Kind() {
+		case reflect.Bool:
+			schema["type"] = "boolean"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			schema["type"] = "integer"
+		case reflect.Float32, reflect.Float64:
+			schema["type"] = "number"
+		case reflect.String:
+			schema["type"] = "string"
+		case reflect.Slice, reflect.Array:
+			schema["type"] = "array"
+			schema["items"] = b.typeSchema(t.Elem(), dive)
+		case reflect.Map:
+			schema["type"] = "object"
+			schema["additionalProperties"] = b.typeSchema(t.Elem(), dive)
+		case reflect.Struct:
+			if b.building[t] {
+				return map[string]interface{}{"$ref": b.ref(t)}
+			}
+			b.building[t] = true
+			schema["type"] = "object"
+			properties := map[string]interface{}{}
+			var required []string
+			b.structSchema(t, properties, &required)
+			schema["properties"] = properties
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			delete(b.building, t)
+			if ref, ok := b.refs[t]; ok && t != b.root {
+				b.defs[strings.TrimPrefix(ref, "#/definitions/")] = schema
+				schema = map[string]interface{}{"$ref": ref}
+			}
+		}
+	}
+	applyRules(schema, rules)
+	return schema
+}
+
+//根结构体引用自身用#，其他的放在definitions中
+func (b *schemaBuilder) ref(t reflect.Type) string {
+	if ref, ok := b.refs[t]; ok {
+		return ref
+	}
+	ref := "#"
+	if t != b.root {
+		name := t.Name()
+		for i := 2; b.names[name]; i++ { //不同包的同名类型
+			name = t.Name() + strconv.Itoa(i)
+		}
+		b.names[name] = true
+		ref = "#/definitions/" + name
+	}
+	b.refs[t] = ref
+	return ref
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct { //嵌入的结构体字段是平铺的
+			b.structSchema(ft, properties, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		validate := f.Tag.Get("validate")
+		properties[name] = b.typeSchema(f.Type, validate)
+		rules, _ := splitDive(validate)
+		if hasRule(rules, "required") && !hasRule(rules, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func hasRule(rules []string, name string) bool {
+	for _, rule := range rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+//dive之前的规则作用于本身，之后的作用于元素
+func splitDive(validate string) (rules []string, dive string) {
+	if validate == "" {
+		return
+	}
+	ss := strings.SplitN(validate, ",dive", 2)
+	if len(ss) == 2 {
+		dive = strings.TrimPrefix(ss[1], ",")
+	} else if strings.HasPrefix(validate, "dive") {
+		return nil, strings.TrimPrefix(strings.TrimPrefix(validate, "dive"), ",")
+	}
+	return strings.Split(ss[0], ","), dive
+}
+
+//有omitempty时零值不校验，零值也能通过
+func applyRules(schema map[string]interface{}, rules []string) {
+	typ, _ := schema["type"].(string)
+	constraints := map[string]interface{}{}
+	for _, rule := range rules {
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name, param := kv[0], kv[1]
+		if name == "oneof" {
+			var enum []interface{}
+			for _, s := range strings.Fields(param) {
+				if typ == "integer" || typ == "number" {
+					if f, err := strconv.ParseFloat(s, 64); err == nil {
+						enum = append(enum, f)
+						continue
+					}
+				}
+				enum = append(enum, s)
+			}
+			constraints["enum"] = enum
+			continue
+		}
+		f, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			continue
+		}
+		var key string
+		switch typ {
+		case "integer", "number":
+			key = map[string]string{
+				"min": "minimum", "gte": "minimum",
+				"max": "maximum", "lte": "maximum",
+				"gt": "exclusiveMinimum", "lt": "exclusiveMaximum",
+			}[name]
+		case "string":
+			key = map[string]string{"min": "minLength", "gte": "minLength", "max": "maxLength", "lte": "maxLength"}[name]
+			if name == "len" {
+				constraints["minLength"], constraints["maxLength"] = f, f
+			}
+		case "array":
+			key = map[string]string{"min": "minItems", "gte": "minItems", "max": "maxItems", "lte": "maxItems"}[name]
+			if name == "len" {
+				constraints["minItems"], constraints["maxItems"] = f, f
+			}
+		case "object":
+			key = map[string]string{"min": "minProperties", "gte": "minProperties", "max": "maxProperties", "lte": "maxProperties"}[name]
+		}
+		if key != "" {
+			constraints[key] = f
+		}
+	}
+	if len(constraints) == 0 {
+		return
+	}
+	zero, ok := zeroSchema[typ]
+	if !hasRule(rules, "omitempty") || !ok {
+		for k, v := range constraints {
+			schema[k] = v
+		}
+		return
+	}
+	if enum, ok := constraints["enum"].([]interface{}); ok && len(constraints) == 1 { //零值加到enum中，编辑器提示更友好
+		schema["enum"] = append([]interface{}{zero["const"]}, enum...)
+		return
+	}
+	schema["anyOf"] = []interface{}{zero, constraints}
+}
+
+//各类型的零值
+var zeroSchema = map[string]map[string]interface{}{
+	"integer": {"const": 0},
+	"number":  {"const": 0},
+	"string":  {"const": ""},
+	"array":   {"maxItems": 0},
+	"object":  {"maxProperties": 0},
+}
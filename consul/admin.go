@@ -0,0 +1,329 @@
+package consul
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+//本实例在consul中的状态
+type ServiceState struct {
+	ServiceId         string            `json:"service_id"`
+	ServiceName       string            `json:"service_name"`
+	Address           string            `json:"address"`
+	Port              int               `json:"port"`
+	Tags              []string          `json:"tags"`
+	Meta              map[string]string `json:"meta"`
+	Maintenance       bool              `json:"maintenance"`
+	MaintenanceReason string            `json:"maintenance_reason,omitempty"`
+	WeightPassing     int               `json:"weight_passing"`
+	WeightWarning     int               `json:"weight_warning"`
+}
+
+//管理接口对consul的操作，默认用Client，单测时可替换
+type AdminBackend interface {
+	State(serviceId string) (ServiceState, error)
+	SetMaintenance(serviceId string, enable bool, reason string) error
+	SetWeights(serviceId string, passing, warning int) error
+}
+
+//返回err则拒绝请求
+type Authorizer func(r *http.Request) error
+
+//只允许本机访问，经过反向代理时RemoteAddr是代理的地址，应当用TokenAuthorizer
+func LocalOnly(r *http.Request) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("remote addr %s is not loopback", r.RemoteAddr)
+	}
+	return nil
+}
+
+//要求Header中带有 Authorization: Bearer <token>
+func TokenAuthorizer(token string) Authorizer {
+	return func(r *http.Request) error {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return fmt.Errorf("invalid token")
+		}
+		return nil
+	}
+}
+
+func AllowAll(r *http.Request) error {
+	return nil
+}
+
+type AdminConfig struct {
+	ServiceId  string       //默认为Register注册的服务
+	Authorizer Authorizer   //默认LocalOnly
+	Backend    AdminBackend //默认用Client
+	//consul修改成功后调用，返回err则把consul恢复成old，例如摘除流量后停止消费队列
+	OnChange func(ctx context.Context, old, new ServiceState) error
+}
+
+//服务维护、流量控制的管理接口
+type Admin struct {
+	config AdminConfig
+}
+
+//同一时间只有一个修改
+var adminMu sync.Mutex
+
+func NewAdmin(config AdminConfig) *Admin {
+	if config.Authorizer == nil {
+		config.Authorizer = LocalOnly
+	}
+	if config.Backend == nil {
+		config.Backend = clientAdminBackend{}
+	}
+	return &Admin{config: config}
+}
+
+func (m *Admin) serviceId() (string, error) {
+	if m.config.ServiceId != "" {
+		return m.config.ServiceId, nil
+	}
+	if serviceId == "" {
+		return "", fmt.Errorf("service not registered")
+	}
+	return serviceId, nil
+}
+
+func (m *Admin) State() (ServiceState, error) {
+	id, err := m.serviceId()
+	if err != nil {
+		return ServiceState{}, err
+	}
+	return m.config.Backend.State(id)
+}
+
+//enable为true时进入维护状态，不再有流量，已经是该状态时什么也不做，返回changed为false，
+//已经在维护状态时reason为空则保留原来的reason
+func (m *Admin) SetMaintenance(ctx context.Context, enable bool, reason string) (state ServiceState, changed bool, err error) {
+	return m.apply(ctx, func(s *ServiceState) {
+		if !enable {
+			s.MaintenanceReason = ""
+		} else if reason != "" || !s.Maintenance {
+			s.MaintenanceReason = reason
+		}
+		s.Maintenance = enable
+	}, func(id string, s ServiceState) error {
+		return m.config.Backend.SetMaintenance(id, s.Maintenance, s.MaintenanceReason)
+	})
+}
+
+//修改权重，调用方按它加权（例如Resolver的weighted），warning为0时保留原来的
+func (m *Admin) SetWeights(ctx context.Context, passing, warning int) (state ServiceState, changed bool, err error) {
+	if passing < 1 || warning < 0 {
+		return ServiceState{}, false, fmt.Errorf("invalid weights passing %d warning %d", passing, warning)
+	}
+	return m.apply(ctx, func(s *ServiceState) {
+		s.WeightPassing = passing
+		if warning != 0 {
+			s.WeightWarning = warning
+		}
+	}, func(id string, s ServiceState) error {
+		return m.config.Backend.SetWeights(id, s.WeightPassing, s.WeightWarning)
+	})
+}
+
+//修改consul后调用OnChange，失败则用set恢复
+func (m *Admin) apply(ctx context.Context, modify func(s *ServiceState), set func(id string, s ServiceState) error) (ServiceState, bool, error) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	id, err := m.serviceId()
+	if err != nil {
+		return ServiceState{}, false, err
+	}
+	old, err := m.config.Backend.State(id)
+	if err != nil {
+		return ServiceState{}, false, err
+	}
+	state := old
+	modify(&state)
+	if reflect.DeepEqual(old, state) {
+		return old, false, nil
+	}
+	if err = set(id, state); err != nil {
+		return old, false, err
+	}
+	if m.config.OnChange != nil {
+		if err = m.config.OnChange(ctx, old, state); err != nil {
+			err = fmt.Errorf("callback failed: %s", err)
+			if e := set(id, old); e != nil {
+				err = fmt.Errorf("%s, rollback failed: %s", err, e)
+			}
+			return old, false, err
+		}
+	}
+	return state, true, nil
+}
+
+type adminResponse struct {
+	Changed bool          `json:"changed"`
+	State   *ServiceState `json:"state,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func writeAdmin(w http.ResponseWriter, status int, resp adminResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (m *Admin) handle(fn func(r *http.Request) (ServiceState, bool, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.config.Authorizer(r); err != nil {
+			writeAdmin(w, http.StatusForbidden, adminResponse{Error: err.Error()})
+			return
+		}
+		state, changed, err := fn(r)
+		if _, ok := err.(badRequest); ok {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeAdmin(w, http.StatusInternalServerError, adminResponse{Error: err.Error()})
+			return
+		}
+		writeAdmin(w, http.StatusOK, adminResponse{Changed: changed, State: &state})
+	})
+}
+
+//请求参数错误，返回400
+type badRequest struct {
+	error
+}
+
+//GET 返回ServiceState
+func (m *Admin) StateHandler() http.Handler {
+	return m.handle(func(r *http.Request) (ServiceState, bool, error) {
+		state, err := m.State()
+		return state, false, err
+	})
+}
+
+type MaintenanceIn struct {
+	Maintenance bool   `json:"maintenance"` //true进入维护状态
+	Reason      string `json:"reason"`
+}
+
+//PUT {"maintenance":true,"reason":"deploying"}
+func (m *Admin) MaintenanceHandler() http.Handler {
+	return m.handle(func(r *http.Request) (ServiceState, bool, error) {
+		var in MaintenanceIn
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			return ServiceState{}, false, badRequest{err}
+		}
+		return m.SetMaintenance(r.Context(), in.Maintenance, in.Reason)
+	})
+}
+
+type WeightsIn struct {
+	Passing int `json:"passing" validate:"min=1"`
+	Warning int `json:"warning" validate:"min=0"` //0则不修改
+}
+
+//PUT {"passing":10,"warning":1}
+func (m *Admin) WeightsHandler() http.Handler {
+	return m.handle(func(r *http.Request) (ServiceState, bool, error) {
+		var in WeightsIn
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			return ServiceState{}, false, badRequest{err}
+		}
+		if err := vali.Struct(in); err != nil {
+			return ServiceState{}, false, badRequest{err}
+		}
+		return m.SetWeights(r.Context(), in.Passing, in.Warning)
+	})
+}
+
+const serviceMaintenancePrefix = "_service_maintenance:" //consul维护状态的check id前缀
+
+type clientAdminBackend struct{}
+
+func (clientAdminBackend) State(id string) (ServiceState, error) {
+	services, err := Client.Agent().Services()
+	if err != nil {
+		return ServiceState{}, err
+	}
+	s, ok := services[id]
+	if !ok {
+		return ServiceState{}, fmt.Errorf("service %s not found in consul agent", id)
+	}
+	checks, err := Client.Agent().Checks()
+	if err != nil {
+		return ServiceState{}, err
+	}
+	state := ServiceState{
+		ServiceId:     s.ID,
+		ServiceName:   s.Service,
+		Address:       s.Address,
+		Port:          s.Port,
+		Tags:          s.Tags,
+		Meta:          s.Meta,
+		WeightPassing: s.Weights.Passing,
+		WeightWarning: s.Weights.Warning,
+	}
+	if check, ok := checks[serviceMaintenancePrefix+id]; ok {
+		state.Maintenance = true
+		state.MaintenanceReason = check.Notes
+	}
+	return state, nil
+}
+
+func (clientAdminBackend) SetMaintenance(id string, enable bool, reason string) error {
+	if enable {
+		return Client.Agent().EnableServiceMaintenance(id, reason)
+	}
+	return Client.Agent().DisableServiceMaintenance(id)
+}
+
+//consul只能通过重新注册修改权重，用agent中当前的定义重新注册，不带check，
+//agent会保留已有的check及其状态，所以不会把critical的实例重置为passing，其他进程注册的服务也能修改
+func (clientAdminBackend) SetWeights(id string, passing, warning int) error {
+	services, err := Client.Agent().Services()
+	if err != nil {
+		return err
+	}
+	s, ok := services[id]
+	if !ok {
+		return fmt.Errorf("service %s not found in consul agent", id)
+	}
+	if err = Client.Agent().ServiceRegister(weightsRegistration(s, passing, warning)); err != nil {
+		return err
+	}
+	if registration != nil && registration.ID == id { //之后用registration重新注册时保持新的权重
+		reg := *registration
+		reg.Weights = &api.AgentWeights{Passing: passing, Warning: warning}
+		registration = &reg
+	}
+	return nil
+}
+
+func weightsRegistration(s *api.AgentService, passing, warning int) *api.AgentServiceRegistration {
+	return &api.AgentServiceRegistration{
+		Kind:              s.Kind,
+		ID:                s.ID,
+		Name:              s.Service,
+		Tags:              s.Tags,
+		Port:              s.Port,
+		Address:           s.Address,
+		EnableTagOverride: s.EnableTagOverride,
+		Meta:              s.Meta,
+		Weights:           &api.AgentWeights{Passing: passing, Warning: warning},
+		Proxy:             s.Proxy,
+		Connect:           s.Connect,
+	}
+}
@@ -0,0 +1,129 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeAdminBackend struct {
+	state ServiceState
+	calls int
+}
+
+func (m *fakeAdminBackend) State(id string) (ServiceState, error) {
+	return m.state, nil
+}
+
+func (m *fakeAdminBackend) SetMaintenance(id string, enable bool, reason string) error {
+	m.calls++
+	m.state.Maintenance, m.state.MaintenanceReason = enable, reason
+	return nil
+}
+
+func (m *fakeAdminBackend) SetWeights(id string, passing, warning int) error {
+	m.calls++
+	m.state.WeightPassing, m.state.WeightWarning = passing, warning
+	return nil
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	backend := &fakeAdminBackend{state: ServiceState{ServiceId: "s", WeightPassing: 1, WeightWarning: 1}}
+	var callbackErr error
+	var changes []string
+	admin := NewAdmin(AdminConfig{
+		ServiceId: "s",
+		Backend:   backend,
+		OnChange: func(ctx context.Context, old, new ServiceState) error {
+			changes = append(changes, fmt.Sprintf("%v->%v", old.Maintenance, new.Maintenance))
+			return callbackErr
+		},
+	})
+
+	if _, changed, err := admin.SetMaintenance(ctx, true, "deploying"); err != nil || !changed {
+		t.Fatal(changed, err)
+	}
+	if state, changed, err := admin.SetMaintenance(ctx, true, ""); err != nil || changed || state.MaintenanceReason != "deploying" { //幂等
+		t.Error(state, changed, err)
+	}
+
+	//callback失败则恢复
+	callbackErr = fmt.Errorf("stop consumer failed")
+	if _, _, err := admin.SetMaintenance(ctx, false, ""); err == nil || !strings.Contains(err.Error(), "stop consumer failed") {
+		t.Error(err)
+	}
+	if !backend.state.Maintenance || backend.state.MaintenanceReason != "deploying" {
+		t.Errorf("not rollback %+v", backend.state)
+	}
+	if got := fmt.Sprint(changes, backend.calls); got != "[false->true true->false] 3" {
+		t.Errorf("get %s", got)
+	}
+
+	callbackErr = nil
+	if state, changed, err := admin.SetWeights(ctx, 10, 0); err != nil || !changed || state.WeightPassing != 10 || state.WeightWarning != 1 {
+		t.Error(state, changed, err)
+	}
+	if _, _, err := admin.SetWeights(ctx, 0, 0); err == nil {
+		t.Error("want err")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	backend := &fakeAdminBackend{state: ServiceState{ServiceId: "s", WeightPassing: 1, WeightWarning: 1}}
+	admin := NewAdmin(AdminConfig{
+		ServiceId:  "s",
+		Backend:    backend,
+		Authorizer: TokenAuthorizer("secret"),
+	})
+	for _, test := range []struct {
+		handler http.Handler
+		token   string
+		body    string
+		status  int
+		want    string
+	}{
+		{admin.StateHandler(), "wrong", "", http.StatusForbidden, `"error":"invalid token"`},
+		{admin.MaintenanceHandler(), "secret", `{"maintenance":true,"reason":"deploying"}`, http.StatusOK, `"changed":true`},
+		{admin.MaintenanceHandler(), "secret", `{"maintenance":true}`, http.StatusOK, `"changed":false`},
+		{admin.WeightsHandler(), "secret", `{"passing":0}`, http.StatusBadRequest, `"error"`},
+		{admin.WeightsHandler(), "secret", `{"passing":5}`, http.StatusOK, `"weight_passing":5`},
+		{admin.StateHandler(), "secret", "", http.StatusOK, `"maintenance_reason":"deploying"`},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(test.body))
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, r)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s get %d %s", test.body, w.Code, w.Body.String())
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil) //RemoteAddr是192.0.2.1
+	w := httptest.NewRecorder()
+	NewAdmin(AdminConfig{ServiceId: "s", Backend: backend}).StateHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("get %d", w.Code)
+	}
+}
+
+func TestWeightsRegistration(t *testing.T) {
+	reg := weightsRegistration(&api.AgentService{
+		ID:      "s-1",
+		Service: "s",
+		Tags:    []string{"v1"},
+		Address: "10.0.0.1",
+		Port:    80,
+		Weights: api.AgentWeights{Passing: 1, Warning: 1},
+	}, 10, 2)
+	if reg.ID != "s-1" || reg.Name != "s" || reg.Address != "10.0.0.1" || reg.Port != 80 || *reg.Weights != (api.AgentWeights{Passing: 10, Warning: 2}) {
+		t.Errorf("get %+v", reg)
+	}
+	if reg.Check != nil || reg.Checks != nil { //带check会把已有check的状态重置
+		t.Errorf("get checks %v %v", reg.Check, reg.Checks)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/go-playground/validator"
 	"github.com/hashicorp/consul/api"
-	"github.com/hashicorp/consul/api/watch"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"reflect"
@@ -44,67 +43,40 @@ func GetValue(key string) (value []byte) {
 	return getValue(key, defaultConsul)
 }
 func getValue(key string, lo Consul) (value []byte) {
-	entry := logrus.WithField("key", key)
+	value, err := loadValue(key, lo)
+	if err != nil {
+		logrus.WithField("key", key).WithError(err).Panic()
+	}
+	//entry = entry.WithField("bs", string(value))
+	//entry.Info("consul get bs ok")
+	return value
+}
 
+func fullKey(key string, lo Consul) string {
 	prefix := Prefix
 	if lo.prefixPtr != nil {
 		prefix = *lo.prefixPtr
 	}
-
-	pair, _, err := KV.Get(fmt.Sprintf("%s/%s", prefix, key), nil)
-	if err != nil {
-		entry.WithError(err).Panic()
-	}
-	if pair == nil {
-		entry.Panic("consul has't key")
-	}
-	//entry = entry.WithField("bs", string(value))
-	//entry.Info("consul get bs ok")
-	return pair.Value
+	return fmt.Sprintf("%s/%s", prefix, key)
 }
 
 func (m Consul) GetValue(key string) (value []byte) {
 	return getValue(key, m)
 }
 
-var kv = map[string]reflect.Value{}
+var (
+	kv   = map[string]reflect.Value{}
+	kvMu sync.Mutex //LoadJson等可能在多个协程中同时调用
+)
 
 type Unmarshal func(data []byte, v interface{}) error
 
 func getJson(key string, i interface{}, lo Consul, unmarshal Unmarshal) {
-	t := reflect.TypeOf(i)
-	entry := logrus.WithFields(logrus.Fields{
-		"key":  key,
-		"type": t.String(),
-	})
-
-	switch t.Kind() {
-	case reflect.Ptr:
-		value := getValue(key, lo)
-		entry = entry.WithField("bs", string(value))
-		if err := unmarshal(value, i); err != nil {
-			entry.WithError(err).Panic("consul value invalid")
-		}
-		entry = entry.WithField("value", i)
-		if err := valiVa(lo, i); err != nil {
-			entry.WithError(err).Panicf("vali failed")
-		}
-		entry.Info("consul get value ok")
-		kv[key] = reflect.ValueOf(i)
-		return
-	case reflect.Func:
-		if t.NumIn() != 1 {
-			entry.Panicf("numIn:%d != 1", t.NumIn())
-		}
-		v := reflect.ValueOf(i)
-
-		in0Type := t.In(0)
-		in0Ptr := reflect.New(in0Type).Interface()
-		getJson(key, in0Ptr, lo, unmarshal)
-		v.Call([]reflect.Value{reflect.ValueOf(in0Ptr).Elem()})
-		return
-	default:
-		entry.Panicf("invalid value kind:%s", t.Kind())
+	if err := loadJson(key, i, lo, unmarshal); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"key":  key,
+			"type": reflect.TypeOf(i).String(),
+		}).WithError(err).Panic("consul get value failed")
 	}
 }
 
@@ -123,6 +95,9 @@ type Consul struct {
 	tag         string
 	prefixPtr   *string
 	locker      sync.Locker
+	source      Source
+	defaultPtr  *interface{}
+	layers      []string
 }
 
 var defaultConsul Consul //默认的
@@ -192,25 +167,17 @@ func WatchYaml(key string, ptr interface{}, handler func()) {
 }
 
 func watchJson(key string, ptr interface{}, handler func(), lo Consul, unmarshal Unmarshal) {
-	prefix := Prefix
-	if lo.prefixPtr != nil {
-		prefix = *lo.prefixPtr
-	}
-
-	plan, err := watch.Parse(map[string]interface{}{
-		"type": "key",
-		"key":  fmt.Sprintf("%s/%s", prefix, key),
-	})
-	if err != nil {
-		logrus.WithField("key", key).
-			WithError(err).
-			Panic("consul watch parse failed")
-	}
 	getJson(key, ptr, lo, unmarshal)
 	if handler != nil {
 		handler()
 	}
-	plan.Handler = func(idx uint64, raw interface{}) {
+	var mu sync.Mutex //分层时多个key的watch可能并发回调
+	onChange := func(pair *Pair) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(lo.layers) > 0 { //任何一层变化都重新合并
+			pair = loadLayersPair(key, lo, unmarshal)
+		}
 		if lo.locker != nil {
 			lo.locker.Lock() //避免竞争, 例如map并发修改会panic
 			defer lo.locker.Unlock()
@@ -223,37 +190,48 @@ func watchJson(key string, ptr interface{}, handler func(), lo Consul, unmarshal
 				entry.Errorf("panic: %v", r)
 			}
 		}()
-		if kv, ok := raw.(*api.KVPair); ok && kv != nil {
-			value = kv.Value
+		if pair != nil {
+			value = pair.Value
+			rt := reflect.TypeOf(ptr)
+			old := mask(reflect.ValueOf(ptr).Elem().Interface()) //获取指向的值, 不然指针变了会打印新值
 			entry = entry.WithFields(logrus.Fields{
-				"bs":        string(value),
-				"value_old": reflect.ValueOf(ptr).Elem().Interface(), //获取指向的值, 不然指针变了会打印新值
+				"bs":        maskBs(value, rt, unmarshal),
+				"value_old": old,
 			})
 
-			rt := reflect.TypeOf(ptr)
 			tmp := reflect.New(rt.Elem()).Interface() //先在临时变量上修改, 没问题再设置, 如同nginx -s reload
-			if err := unmarshal(value, &tmp); err != nil {
+			plain, err := decrypt(value, unmarshal)
+			if err == nil {
+				err = unmarshal(plain, &tmp)
+			}
+			if err != nil {
 				entry.WithError(err).Errorf("consul watch unmarshal json failed")
+				recordReload(fullKey(key, lo), pair.Index, old, []byte(maskBs(value, rt, unmarshal)), err)
 				return
 			}
-			entry = entry.WithField("value_new", tmp)
+			entry = entry.WithField("value_new", mask(tmp))
 			if err := valiVa(lo, tmp); err != nil {
 				entry.WithError(err).Error("vali failed")
+				recordReload(fullKey(key, lo), pair.Index, old, mask(tmp), err)
 				return
 			}
 			reflect.ValueOf(ptr).Elem().Set(reflect.ValueOf(tmp).Elem())
+			recordReload(fullKey(key, lo), pair.Index, old, mask(tmp), nil)
 			entry.Info("consul watch value ok")
 			if handler != nil {
 				handler() //启动时会起个线程执行一次，发生修改后回调
 			}
 		} else {
-			entry.WithFields(logrus.Fields{
-				"idx": idx,
-				"raw": raw,
-			}).Errorf("consul watch invalid raw")
+			entry.Errorf("consul watch invalid raw")
+		}
+	}
+	for _, k := range layerKeys(key, lo) {
+		if err := lo.getSource().Watch(k, onChange); err != nil {
+			logrus.WithField("key", k).
+				WithError(err).
+				Panic("consul watch failed")
 		}
 	}
-	go plan.Run(Address)
 }
 
 func (m Consul) WatchJsonVarious(key string, i interface{}) {
@@ -0,0 +1,127 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//watch到的一次配置变化
+type Revision struct {
+	Key     string    `json:"key"`
+	Index   uint64    `json:"index"` //consul的ModifyIndex
+	Time    time.Time `json:"time"`
+	Applied bool      `json:"applied"`          //false表示被拒绝，保留了旧值
+	Reason  string    `json:"reason,omitempty"` //被拒绝的原因
+	Diff    []string  `json:"diff,omitempty"`
+}
+
+//最多保留多少条历史，超过则丢弃最早的
+var HistorySize = 100
+
+var history struct {
+	sync.Mutex
+	revisions []Revision
+}
+
+//返回按时间顺序的历史，key不为空时只返回该key的
+func History(key string) (revisions []Revision) {
+	history.Lock()
+	defer history.Unlock()
+	for _, revision := range history.revisions {
+		if key == "" || revision.Key == key {
+			revisions = append(revisions, revision)
+		}
+	}
+	return
+}
+
+//用于debug，返回json格式的历史，可用query参数key过滤
+func HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(History(r.URL.Query().Get("key")))
+	})
+}
+
+//记录历史和监控，old和new用于生成diff，err不为nil表示被拒绝
+func recordReload(key string, index uint64, old, new interface{}, err error) {
+	revision := Revision{
+		Key:     key,
+		Index:   index,
+		Time:    time.Now(),
+		Applied: err == nil,
+		Diff:    diff(old, new),
+	}
+	result := "success"
+	if err != nil {
+		revision.Reason = err.Error()
+		result = "failure"
+	}
+	if MetricReload != nil {
+		MetricReload(key, result).Inc()
+	}
+	history.Lock()
+	defer history.Unlock()
+	history.revisions = append(history.revisions, revision)
+	if n := len(history.revisions) - HistorySize; n > 0 {
+		history.revisions = append([]Revision{}, history.revisions[n:]...)
+	}
+}
+
+//转成json后逐字段比较，返回 "+a.b: 1" "-a.c: 2" "a.d: 3 -> 4" 格式的差异
+func diff(old, new interface{}) (lines []string) {
+	diffValue("", toGeneric(old), toGeneric(new), &lines)
+	return
+}
+
+func toGeneric(v interface{}) (generic interface{}) {
+	if bs, ok := v.([]byte); ok { //unmarshal失败时只有原始值
+		if unmarshalGeneric(bs, &generic, json.Unmarshal) != nil {
+			return string(bs)
+		}
+		return
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	unmarshalGeneric(bs, &generic, json.Unmarshal)
+	return
+}
+
+func diffValue(path string, old, new interface{}, lines *[]string) {
+	oldMap, ok1 := old.(map[string]interface{})
+	newMap, ok2 := new.(map[string]interface{})
+	if ok1 && ok2 {
+		var keys []string
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffValue(strings.TrimPrefix(path+"."+k, "."), oldMap[k], newMap[k], lines)
+		}
+		return
+	}
+	oldBs, _ := json.Marshal(old)
+	newBs, _ := json.Marshal(new)
+	switch {
+	case string(oldBs) == string(newBs):
+	case old == nil:
+		*lines = append(*lines, fmt.Sprintf("+%s: %s", path, newBs))
+	case new == nil:
+		*lines = append(*lines, fmt.Sprintf("-%s: %s", path, oldBs))
+	default:
+		*lines = append(*lines, fmt.Sprintf("%s: %s -> %s", path, oldBs, newBs))
+	}
+}
@@ -0,0 +1,39 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	src := NewMapSource(map[string]string{
+		"history/d": `{"d":"1s"}`,
+	})
+	var tmp Tmp
+	WithSource(src).WithPrefix("history").ValiStruct().WatchJson("d", &tmp, nil)
+	src.Set("history/d", []byte(`{"d":"2s"}`))
+	src.Set("history/d", []byte(`{}`)) //校验失败
+	src.Set("history/d", []byte(`{`))  //unmarshal失败
+
+	revisions := History("history/d")
+	if len(revisions) != 3 {
+		t.Fatalf("get revisions %+v", revisions)
+	}
+	if r := revisions[0]; !r.Applied || len(r.Diff) != 1 || r.Diff[0] != `d.Duration: 1000000000 -> 2000000000` {
+		t.Errorf("get revision %+v", r)
+	}
+	if r := revisions[1]; r.Applied || r.Reason == "" || r.Index <= revisions[0].Index {
+		t.Errorf("get revision %+v", r)
+	}
+	if r := revisions[2]; r.Applied || len(r.Diff) != 1 { //原始值无法解析，整体比较
+		t.Errorf("get revision %+v", r)
+	}
+
+	w := httptest.NewRecorder()
+	HistoryHandler().ServeHTTP(w, httptest.NewRequest("GET", "/?key=history/d", nil))
+	var got []Revision
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || len(got) != 3 {
+		t.Errorf("get %s err %v", w.Body.String(), err)
+	}
+}
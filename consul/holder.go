@@ -0,0 +1,117 @@
+package consul
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+//热更新的配置，每次变化都原子地替换成一个新的快照，读取无需加锁
+//与WithLocker().WatchJson()相比，读者不会被阻塞，也不会读到改了一半的值
+type Holder struct {
+	value   atomic.Value //holderSnapshot
+	mu      sync.Mutex
+	subs    []func(old, new interface{})
+	version uint64
+}
+
+type holderSnapshot struct {
+	value interface{}
+}
+
+//value是配置的零值，用于确定类型，例如HoldJson("mysql", mysql.Config{})，
+//之后用holder.Get().(mysql.Config)读取，初始读取失败会panic，之后的修改如果unmarshal或校验失败则不生效
+func HoldJson(key string, value interface{}) *Holder {
+	return holdJson(key, value, defaultConsul, json.Unmarshal)
+}
+
+func HoldYaml(key string, value interface{}) *Holder {
+	return holdJson(key, value, defaultConsul, yaml.Unmarshal)
+}
+
+func (m Consul) HoldJson(key string, value interface{}) *Holder {
+	return holdJson(key, value, m, json.Unmarshal)
+}
+
+func (m Consul) HoldYaml(key string, value interface{}) *Holder {
+	return holdJson(key, value, m, yaml.Unmarshal)
+}
+
+func holdJson(key string, value interface{}, lo Consul, unmarshal Unmarshal) *Holder {
+	holder := &Holder{}
+	ptr := reflect.New(reflect.TypeOf(value)) //只在watch协程中修改，每次都是新unmarshal出来的值，所以复制后的快照不会再被修改
+	watchJson(key, ptr.Interface(), func() {
+		holder.store(ptr.Elem().Interface())
+	}, lo, unmarshal)
+	return holder
+}
+
+//watch前缀下的所有key，value是map[string]T的零值，之后用holder.Get().(map[string]T)读取，
+//每次变化都复制出一个新的map作为快照，某个key失败时的处理见WatchPrefixJson
+func HoldPrefixJson(prefix string, value interface{}) *Holder {
+	return holdPrefix(prefix, value, defaultConsul, json.Unmarshal)
+}
+
+func HoldPrefixYaml(prefix string, value interface{}) *Holder {
+	return holdPrefix(prefix, value, defaultConsul, yaml.Unmarshal)
+}
+
+func (m Consul) HoldPrefixJson(prefix string, value interface{}) *Holder {
+	return holdPrefix(prefix, value, m, json.Unmarshal)
+}
+
+func (m Consul) HoldPrefixYaml(prefix string, value interface{}) *Holder {
+	return holdPrefix(prefix, value, m, yaml.Unmarshal)
+}
+
+func holdPrefix(prefix string, value interface{}, lo Consul, unmarshal Unmarshal) *Holder {
+	holder := &Holder{}
+	ptr := reflect.New(reflect.TypeOf(value)) //只在watch协程中修改，所以复制一份给读者
+	watchPrefix(prefix, ptr.Interface(), func(errs map[string]error) {
+		mp := ptr.Elem()
+		snapshot := reflect.MakeMapWithSize(mp.Type(), mp.Len())
+		for iter := mp.MapRange(); iter.Next(); {
+			snapshot.SetMapIndex(iter.Key(), iter.Value())
+		}
+		holder.store(snapshot.Interface())
+	}, lo, unmarshal)
+	return holder
+}
+
+//返回当前快照，不要修改快照中的map、slice等
+func (m *Holder) Get() interface{} {
+	return m.value.Load().(holderSnapshot).value
+}
+
+//每次值变化加1，初始值为1
+func (m *Holder) Version() uint64 {
+	return atomic.LoadUint64(&m.version)
+}
+
+//配置更新后回调，在watch协程中顺序执行
+func (m *Holder) Subscribe(fn func(old, new interface{})) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = append(m.subs, fn)
+}
+
+//初始读取不通知，watch重复推送相同的值（例如启动后的第一次回调）时什么也不做
+func (m *Holder) store(value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot, ok := m.value.Load().(holderSnapshot)
+	if ok && reflect.DeepEqual(snapshot.value, value) {
+		return
+	}
+	m.value.Store(holderSnapshot{value: value})
+	atomic.AddUint64(&m.version, 1)
+	if !ok {
+		return
+	}
+	old := snapshot.value
+	for _, fn := range m.subs {
+		fn(old, value)
+	}
+}
@@ -0,0 +1,66 @@
+package consul
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHolder(t *testing.T) {
+	src := NewMapSource(map[string]string{
+		"test/d": `{"d":"1s"}`,
+	})
+	holder := WithSource(src).WithPrefix("test").ValiStruct().HoldJson("d", Tmp{})
+	if d := holder.Get().(Tmp).D.Duration; d != time.Second || holder.Version() != 1 {
+		t.Fatalf("get %s version %d", d, holder.Version())
+	}
+
+	var olds, news []time.Duration
+	holder.Subscribe(func(old, new interface{}) {
+		olds = append(olds, old.(Tmp).D.Duration)
+		news = append(news, new.(Tmp).D.Duration)
+	})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() { //并发读，-race下不应报错
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = holder.Get().(Tmp).D.Duration
+			}
+		}
+	}()
+	src.Set("test/d", []byte(`{"d":"1s"}`)) //值没有变化，不通知
+	src.Set("test/d", []byte(`{"d":"2s"}`))
+	src.Set("test/d", []byte(`{"d":"2s"}`))
+	src.Set("test/d", []byte(`{}`)) //校验失败不生效
+	close(stop)
+	wg.Wait()
+
+	if d := holder.Get().(Tmp).D.Duration; d != 2*time.Second || holder.Version() != 2 {
+		t.Errorf("get %s version %d", d, holder.Version())
+	}
+	if len(olds) != 1 || olds[0] != time.Second || news[0] != 2*time.Second {
+		t.Errorf("get olds %v news %v", olds, news)
+	}
+}
+
+func TestHoldPrefix(t *testing.T) {
+	src := NewMapSource(map[string]string{
+		"test/p/a": `{"d":"1s"}`,
+	})
+	holder := WithSource(src).WithPrefix("test").ValiStruct().HoldPrefixJson("p", map[string]Tmp{})
+	first := holder.Get().(map[string]Tmp)
+	src.Set("test/p/b", []byte(`{"d":"2s"}`))
+	if len(first) != 1 { //旧快照不会被修改
+		t.Errorf("get %v", first)
+	}
+	if mp := holder.Get().(map[string]Tmp); len(mp) != 2 || mp["b"].D.Duration != 2*time.Second || holder.Version() != 2 {
+		t.Errorf("get %v version %d", mp, holder.Version())
+	}
+}
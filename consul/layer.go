@@ -0,0 +1,123 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+//分层配置，在key的基础上按顺序叠加各层，map会深度合并，其他值直接覆盖，
+//例如Prefix为test/service/example时，WithLayers("common", "prod", "prod/host-x").GetJson("mysql", &my)
+//依次读取 test/service/example/mysql、test/service/example/common/mysql、
+//test/service/example/prod/mysql、test/service/example/prod/host-x/mysql，
+//不存在的层跳过，但至少要有一层，合并后再unmarshal和校验，watch时任何一层变化都会重新合并
+func WithLayers(overlays ...string) Consul {
+	var m Consul
+	return m.WithLayers(overlays...)
+}
+
+func (m Consul) WithLayers(overlays ...string) Consul {
+	m.layers = append([]string{}, overlays...)
+	return m
+}
+
+func layerKeys(key string, lo Consul) []string {
+	keys := []string{fullKey(key, lo)}
+	for _, layer := range lo.layers {
+		keys = append(keys, fullKey(strings.Trim(layer, "/")+"/"+key, lo))
+	}
+	return keys
+}
+
+//没有分层时就是未解密的原始值，分层时返回合并后的json（yaml兼容json，所以yaml.Unmarshal也能用），
+//字段中的加密值由调用者解密
+func loadLayers(key string, lo Consul, unmarshal Unmarshal) ([]byte, error) {
+	if len(lo.layers) == 0 {
+		return loadRawValue(key, lo)
+	}
+	var merged interface{}
+	found := false
+	for _, k := range layerKeys(key, lo) {
+		pair, err := lo.getSource().Get(k)
+		if err != nil {
+			return nil, err
+		}
+		if pair == nil {
+			continue
+		}
+		value, err := decrypt(pair.Value, nil) //整个值加密的层要先解密才能合并，字段中的加密值合并后再解密
+		if err != nil {
+			return nil, fmt.Errorf("layer %s decrypt failed: %s", k, err)
+		}
+		var layer interface{}
+		if err = unmarshalGeneric(value, &layer, unmarshal); err != nil {
+			return nil, fmt.Errorf("layer %s invalid: %s", k, err)
+		}
+		merged = mergeValue(merged, normalize(layer))
+		found = true
+	}
+	if !found {
+		return nil, NotFoundError{Key: fullKey(key, lo)}
+	}
+	return json.Marshal(merged)
+}
+
+//用于watch，出错时打日志并返回nil
+func loadLayersPair(key string, lo Consul, unmarshal Unmarshal) *Pair {
+	value, err := loadLayers(key, lo, unmarshal)
+	if err != nil {
+		logrus.WithField("key", key).WithError(err).Error("consul merge layers failed")
+		return nil
+	}
+	return &Pair{Key: fullKey(key, lo), Value: value}
+}
+
+//src覆盖dst，都是map时递归合并
+func mergeValue(dst, src interface{}) interface{} {
+	dstMap, ok1 := dst.(map[string]interface{})
+	srcMap, ok2 := src.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return src
+	}
+	for k, v := range srcMap {
+		dstMap[k] = mergeValue(dstMap[k], v)
+	}
+	return dstMap
+}
+
+//json解成interface{}时数字默认是float64，超过2^53的整数（id、字节数）会丢失精度，所以用UseNumber，
+//yaml兼容json，所以是合法json时都这样解析
+func unmarshalGeneric(data []byte, v *interface{}, unmarshal Unmarshal) error {
+	if !json.Valid(data) {
+		return unmarshal(data, v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+//yaml解出的map是map[interface{}]interface{}，转成json能处理的map[string]interface{}
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = normalize(vv)
+		}
+		return m
+	case map[string]interface{}:
+		for k, vv := range v {
+			v[k] = normalize(vv)
+		}
+		return v
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = normalize(vv)
+		}
+		return v
+	default:
+		return v
+	}
+}
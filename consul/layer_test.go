@@ -0,0 +1,49 @@
+package consul
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWithLayers(t *testing.T) {
+	src := NewMapSource(map[string]string{
+		"app/db":             `{"url":"base","pool":{"max":10,"idle":2},"id":9007199254740993}`,
+		"app/prod/db":        `{"pool":{"max":50}}`,
+		"app/prod/host-x/db": `{"url":"host-x"}`,
+		"app/c.yaml":         "m:\n  a: 1\n  b: 2\n",
+		"app/prod/c.yaml":    "m:\n  b: 3\n",
+	})
+	lo := WithSource(src).WithPrefix("app").WithLayers("common", "prod", "prod/host-x/")
+
+	type DB struct {
+		Id   int64  `json:"id"`
+		Url  string `json:"url"`
+		Pool struct {
+			Max  int `json:"max"`
+			Idle int `json:"idle"`
+		} `json:"pool"`
+	}
+	var db DB
+	lo.GetJson("db", &db)
+	if got := fmt.Sprint(db); got != "{9007199254740993 host-x {50 2}}" {
+		t.Errorf("get %s", got)
+	}
+
+	var c struct {
+		M map[string]int `yaml:"m"`
+	}
+	lo.GetYaml("c.yaml", &c)
+	if got := fmt.Sprint(c.M); got != "map[a:1 b:3]" {
+		t.Errorf("get %s", got)
+	}
+
+	lo.WatchJson("db", &db, nil)
+	src.Set("app/common/db", []byte(`{"pool":{"idle":5}}`))
+	if got := fmt.Sprint(db); got != "{9007199254740993 host-x {50 5}}" {
+		t.Errorf("after watch get %s", got)
+	}
+	src.Delete("app/prod/host-x/db")
+	if got := fmt.Sprint(db); got != "{9007199254740993 base {50 5}}" {
+		t.Errorf("after delete get %s", got)
+	}
+}
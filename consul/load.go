@@ -0,0 +1,173 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"reflect"
+)
+
+//key不存在
+type NotFoundError struct {
+	Key string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("consul has't key %s", e.Key)
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(NotFoundError)
+	return ok
+}
+
+//key不存在时使用默认值，而不是返回NotFoundError，
+//value的类型必须与GetJson等传入的指针指向的类型（或函数入参类型）相同
+func WithDefault(value interface{}) Consul {
+	var m Consul
+	return m.WithDefault(value)
+}
+
+func (m Consul) WithDefault(value interface{}) Consul {
+	m.defaultPtr = &value
+	return m
+}
+
+//以下LoadXxx出错时返回err，适用于可选的、延迟加载的配置，
+//MustLoadXxx出错时panic，与GetXxx相同，适用于初始化
+func LoadValue(key string) ([]byte, error) {
+	return loadValue(key, defaultConsul)
+}
+
+func (m Consul) LoadValue(key string) ([]byte, error) {
+	return loadValue(key, m)
+}
+
+func LoadJson(key string, i interface{}) error {
+	return loadJson(key, i, defaultConsul, json.Unmarshal)
+}
+
+func (m Consul) LoadJson(key string, i interface{}) error {
+	return loadJson(key, i, m, json.Unmarshal)
+}
+
+func LoadYaml(key string, i interface{}) error {
+	return loadJson(key, i, defaultConsul, yaml.Unmarshal)
+}
+
+func (m Consul) LoadYaml(key string, i interface{}) error {
+	return loadJson(key, i, m, yaml.Unmarshal)
+}
+
+func MustLoadValue(key string) []byte {
+	return getValue(key, defaultConsul)
+}
+
+func (m Consul) MustLoadValue(key string) []byte {
+	return getValue(key, m)
+}
+
+func MustLoadJson(key string, i interface{}) {
+	getJson(key, i, defaultConsul, json.Unmarshal)
+}
+
+func (m Consul) MustLoadJson(key string, i interface{}) {
+	getJson(key, i, m, json.Unmarshal)
+}
+
+func MustLoadYaml(key string, i interface{}) {
+	getJson(key, i, defaultConsul, yaml.Unmarshal)
+}
+
+func (m Consul) MustLoadYaml(key string, i interface{}) {
+	getJson(key, i, m, yaml.Unmarshal)
+}
+
+//整个值加密时解密
+func loadValue(key string, lo Consul) ([]byte, error) {
+	value, err := loadRawValue(key, lo)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(value, nil)
+}
+
+func loadRawValue(key string, lo Consul) ([]byte, error) {
+	k := fullKey(key, lo)
+	pair, err := lo.getSource().Get(k)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, NotFoundError{Key: k}
+	}
+	return pair.Value, nil
+}
+
+func loadJson(key string, i interface{}, lo Consul, unmarshal Unmarshal) (err error) {
+	t := reflect.TypeOf(i)
+	entry := logrus.WithFields(logrus.Fields{
+		"key":  key,
+		"type": t.String(),
+	})
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		value, err := loadLayers(key, lo, unmarshal)
+		if IsNotFound(err) && lo.defaultPtr != nil {
+			return setDefault(i, *lo.defaultPtr, entry)
+		}
+		if err != nil {
+			return err
+		}
+		entry = entry.WithField("bs", maskBs(value, t, unmarshal)) //解密前的值
+		if value, err = decrypt(value, unmarshal); err != nil {
+			entry.WithError(err).Error("consul decrypt value failed")
+			return err
+		}
+		if err = unmarshal(value, i); err != nil {
+			entry.WithError(err).Error("consul value invalid")
+			return err
+		}
+		entry = entry.WithField("value", mask(i))
+		if err = valiVa(lo, i); err != nil {
+			entry.WithError(err).Error("vali failed")
+			return err
+		}
+		entry.Info("consul get value ok")
+		kvMu.Lock()
+		kv[key] = reflect.ValueOf(i)
+		kvMu.Unlock()
+		return nil
+	case reflect.Func:
+		if t.NumIn() != 1 {
+			return fmt.Errorf("numIn:%d != 1", t.NumIn())
+		}
+		v := reflect.ValueOf(i)
+
+		in0Type := t.In(0)
+		in0Ptr := reflect.New(in0Type).Interface()
+		if err = loadJson(key, in0Ptr, lo, unmarshal); err != nil {
+			return
+		}
+		v.Call([]reflect.Value{reflect.ValueOf(in0Ptr).Elem()})
+		return nil
+	default:
+		return fmt.Errorf("invalid value kind:%s", t.Kind())
+	}
+}
+
+func setDefault(ptr, value interface{}, entry *logrus.Entry) error {
+	elem := reflect.ValueOf(ptr).Elem()
+	v := reflect.ValueOf(value)
+	if !v.IsValid() { //nil
+		elem.Set(reflect.Zero(elem.Type()))
+	} else if v.Type().AssignableTo(elem.Type()) {
+		elem.Set(v)
+	} else {
+		return fmt.Errorf("default value type %s not assignable to %s", v.Type(), elem.Type())
+	}
+	entry.WithField("value", mask(value)).Info("consul use default value")
+	return nil
+}
@@ -0,0 +1,73 @@
+package consul
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	zt "zlutils/time"
+)
+
+func TestLoadJson(t *testing.T) {
+	lo := WithSource(NewMapSource(map[string]string{
+		"test/d":       `{"d":"1s"}`,
+		"test/invalid": `{}`,
+	})).WithPrefix("test").ValiStruct()
+
+	var tmp Tmp
+	if err := lo.LoadJson("d", &tmp); err != nil || tmp.D.Duration != time.Second {
+		t.Fatalf("get %v err %v", tmp, err)
+	}
+	if err := lo.LoadJson("none", &tmp); !IsNotFound(err) {
+		t.Errorf("get err %v want NotFoundError", err)
+	}
+	var invalid Tmp
+	if err := lo.LoadJson("invalid", &invalid); err == nil || IsNotFound(err) {
+		t.Errorf("get err %v want vali failed", err)
+	}
+
+	def := Tmp{D: &zt.Duration{Duration: time.Minute}}
+	if err := lo.WithDefault(def).LoadJson("none", func(tmp Tmp) {
+		if tmp.D.Duration != time.Minute {
+			t.Errorf("get %s want default 1m", tmp.D)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lo.WithDefault(1).LoadJson("none", &tmp); err == nil {
+		t.Error("want type mismatch err")
+	}
+}
+
+func TestMustLoadJson(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("want panic")
+		}
+	}()
+	var i int
+	WithSource(NewMapSource(nil)).MustLoadJson("none", &i)
+}
+
+//懒加载时会在多个协程中同时调用
+func TestLoadJsonConcurrent(t *testing.T) {
+	values := map[string]string{}
+	for i := 0; i < 10; i++ {
+		values[fmt.Sprint("concurrent/d", i)] = `{"d":"1s"}`
+	}
+	lo := WithSource(NewMapSource(values)).WithPrefix("concurrent").ValiStruct()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				var tmp Tmp
+				if err := lo.LoadJson(key, &tmp); err != nil || tmp.D.Duration != time.Second {
+					t.Errorf("get %v err %v", tmp, err)
+				}
+			}(fmt.Sprint("d", i))
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,408 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	zt "zlutils/time"
+)
+
+//分布式锁依赖的session和kv操作，默认用Client，单测时用NewMemoryLockBackend
+type LockBackend interface {
+	CreateSession(ttl time.Duration) (session string, err error)
+	//session已失效时返回err
+	RenewSession(session string) error
+	DestroySession(session string) error
+	//key没有被其他session持有时加锁，value用于记录持有者
+	Acquire(key, session string, value []byte) (bool, error)
+	Release(key, session string) error
+	//阻塞到key的持有者可能发生变化，或ctx结束
+	WaitRelease(ctx context.Context, key string) error
+}
+
+type LockConfig struct {
+	TTL           zt.Duration `json:"ttl"`            //session的ttl，进程挂掉后最多过多久锁被释放，默认15s，每ttl/2续约一次
+	RetryInterval zt.Duration `json:"retry_interval"` //consul出错时多久后重试，默认5s
+	Value         string      `json:"value"`          //写入key的值，用于查看谁持有锁，默认hostname
+	Backend       LockBackend `json:"-"`              //默认用Client
+}
+
+//基于consul session的分布式锁，一个Lock同时只能被一个协程持有
+type Lock struct {
+	key     string
+	config  LockConfig
+	mu      sync.Mutex
+	session string
+	cancel  context.CancelFunc
+	done    chan struct{} //续约协程退出后关闭
+}
+
+func NewLock(key string, config LockConfig) *Lock {
+	if config.TTL.Duration == 0 {
+		config.TTL.Duration = 15 * time.Second
+	}
+	if config.RetryInterval.Duration == 0 {
+		config.RetryInterval.Duration = 5 * time.Second
+	}
+	if config.Value == "" {
+		config.Value, _ = os.Hostname()
+	}
+	if config.Backend == nil {
+		config.Backend = clientLockBackend{}
+	}
+	return &Lock{key: key, config: config}
+}
+
+//阻塞到加锁成功或ctx结束，返回的lostCtx在失去锁（session失效、Unlock）时cancel，
+//持有锁期间的工作应当使用lostCtx
+func (m *Lock) Lock(ctx context.Context) (lostCtx context.Context, err error) {
+	for {
+		var ok bool
+		if lostCtx, ok, err = m.TryLock(ctx); ok {
+			return lostCtx, nil
+		}
+		if err == nil {
+			err = m.config.Backend.WaitRelease(ctx, m.key)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logrus.WithField("key", m.key).WithError(err).Warn("consul lock failed, retry later")
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(m.config.RetryInterval.Duration):
+			}
+		}
+	}
+}
+
+//不阻塞，被其他人持有时返回false
+func (m *Lock) TryLock(ctx context.Context) (lostCtx context.Context, ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.session != "" {
+		return nil, false, fmt.Errorf("lock %s already held", m.key)
+	}
+	backend := m.config.Backend
+	session, err := backend.CreateSession(m.config.TTL.Duration)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok, err = backend.Acquire(m.key, session, []byte(m.config.Value)); err != nil || !ok {
+		backend.DestroySession(session)
+		return nil, false, err
+	}
+	lostCtx, cancel := context.WithCancel(ctx)
+	m.session = session
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.renew(lostCtx, session, m.done)
+	logrus.WithFields(logrus.Fields{
+		"key":     m.key,
+		"session": session,
+	}).Info("consul lock acquired")
+	return lostCtx, true, nil
+}
+
+//续约失败则认为失去锁，ctx结束（包括Lock传入的ctx结束）时释放锁
+func (m *Lock) renew(ctx context.Context, session string, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(m.config.TTL.Duration / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.release(session)
+			return
+		case <-ticker.C:
+			if err := m.config.Backend.RenewSession(session); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"key":     m.key,
+					"session": session,
+				}).WithError(err).Error("consul lock lost")
+				m.release(session)
+				return
+			}
+		}
+	}
+}
+
+//释放锁并销毁session，没有持有锁时什么也不做
+func (m *Lock) Unlock() error {
+	m.mu.Lock()
+	session, done := m.session, m.done
+	m.mu.Unlock()
+	if session == "" {
+		return nil
+	}
+	err := m.release(session)
+	<-done
+	return err
+}
+
+func (m *Lock) release(session string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.session != session { //已经被另一个协程释放
+		return nil
+	}
+	m.cancel()
+	m.session = ""
+	err := m.config.Backend.Release(m.key, session)
+	if e := m.config.Backend.DestroySession(session); err == nil {
+		err = e
+	}
+	logrus.WithFields(logrus.Fields{
+		"key":     m.key,
+		"session": session,
+	}).WithError(err).Info("consul lock released")
+	return err
+}
+
+//选主，多个副本中只有一个执行定时任务等
+type Election struct {
+	lock   *Lock
+	leader int32
+}
+
+func NewElection(key string, config LockConfig) *Election {
+	return &Election{lock: NewLock(key, config)}
+}
+
+func (m *Election) IsLeader() bool {
+	return atomic.LoadInt32(&m.leader) == 1
+}
+
+//阻塞到ctx结束，成为leader后调用fn，失去leader时fn的ctx被cancel，
+//fn返回后重新竞选，ctx结束时等fn返回后释放锁，所以fn必须在其ctx结束后尽快返回
+func (m *Election) Run(ctx context.Context, fn func(ctx context.Context)) {
+	for {
+		lostCtx, err := m.lock.Lock(ctx)
+		if err != nil {
+			return
+		}
+		atomic.StoreInt32(&m.leader, 1)
+		fn(lostCtx)
+		atomic.StoreInt32(&m.leader, 0)
+		m.lock.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+type clientLockBackend struct{}
+
+const lockDelay = time.Second
+
+func (clientLockBackend) CreateSession(ttl time.Duration) (string, error) {
+	session, _, err := Client.Session().Create(&api.SessionEntry{
+		TTL:       ttl.String(),
+		Behavior:  api.SessionBehaviorRelease,
+		LockDelay: lockDelay, //session失效后多久才能被再次加锁，避免原持有者还在执行
+	}, nil)
+	return session, err
+}
+
+func (clientLockBackend) RenewSession(session string) error {
+	entry, _, err := Client.Session().Renew(session, nil)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("session %s invalidated", session)
+	}
+	return nil
+}
+
+func (clientLockBackend) DestroySession(session string) error {
+	_, err := Client.Session().Destroy(session, nil)
+	return err
+}
+
+func (clientLockBackend) Acquire(key, session string, value []byte) (bool, error) {
+	ok, _, err := KV.Acquire(&api.KVPair{Key: key, Value: value, Session: session}, nil)
+	return ok, err
+}
+
+func (clientLockBackend) Release(key, session string) error {
+	_, _, err := KV.Release(&api.KVPair{Key: key, Session: session}, nil)
+	return err
+}
+
+func (clientLockBackend) WaitRelease(ctx context.Context, key string) error {
+	var index uint64
+	for {
+		pair, meta, err := KV.Get(key, (&api.QueryOptions{WaitIndex: index}).WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		if pair == nil || pair.Session == "" {
+			if index == 0 { //加锁失败时就已经被释放了，可能还在lock delay中
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(lockDelay):
+				}
+			}
+			return nil
+		}
+		index = meta.LastIndex
+	}
+}
+
+//内存中的LockBackend，常用于单测，
+//与consul的lock delay一样，session失效（而不是主动释放）后，它持有的key在ttl内不能被再次加锁
+type MemoryLockBackend struct {
+	mu       sync.Mutex
+	next     int
+	sessions map[string]time.Time //session -> 过期时间
+	ttls     map[string]time.Duration
+	holders  map[string]string    //key -> session
+	delays   map[string]time.Time //key -> 可以再次加锁的时间
+	changed  chan struct{}        //有变化时关闭并换成新的
+}
+
+func NewMemoryLockBackend() *MemoryLockBackend {
+	return &MemoryLockBackend{
+		sessions: map[string]time.Time{},
+		ttls:     map[string]time.Duration{},
+		holders:  map[string]string{},
+		delays:   map[string]time.Time{},
+		changed:  make(chan struct{}),
+	}
+}
+
+func (m *MemoryLockBackend) CreateSession(ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	session := strconv.Itoa(m.next)
+	m.sessions[session] = time.Now().Add(ttl)
+	m.ttls[session] = ttl
+	return session, nil
+}
+
+func (m *MemoryLockBackend) RenewSession(session string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.valid(session) {
+		return fmt.Errorf("session %s invalidated", session)
+	}
+	m.sessions[session] = time.Now().Add(m.ttls[session])
+	return nil
+}
+
+func (m *MemoryLockBackend) DestroySession(session string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.destroy(session, false)
+	return nil
+}
+
+func (m *MemoryLockBackend) Acquire(key, session string, value []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.valid(session) {
+		return false, fmt.Errorf("session %s invalidated", session)
+	}
+	if holder, _, locked := m.locked(key); locked && holder != session {
+		return false, nil
+	}
+	m.holders[key] = session
+	return true, nil
+}
+
+func (m *MemoryLockBackend) Release(key, session string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.holders[key] == session {
+		delete(m.holders, key)
+		m.notify()
+	}
+	return nil
+}
+
+func (m *MemoryLockBackend) WaitRelease(ctx context.Context, key string) error {
+	m.mu.Lock()
+	_, until, locked := m.locked(key)
+	changed := m.changed
+	m.mu.Unlock()
+	if !locked {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-changed:
+	case <-time.After(time.Until(until)):
+	}
+	return nil
+}
+
+//模拟session在consul中失效，例如持有者与consul断开
+func (m *MemoryLockBackend) Invalidate(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if holder, ok := m.holders[key]; ok {
+		m.destroy(holder, true)
+	}
+}
+
+//返回key当前的持有者session，没有则为空
+func (m *MemoryLockBackend) Holder(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if holder := m.holders[key]; m.valid(holder) {
+		return holder
+	}
+	return ""
+}
+
+//key被持有或在lock delay中时返回true，until是持有者过期或lock delay结束的时间
+func (m *MemoryLockBackend) locked(key string) (holder string, until time.Time, locked bool) {
+	if holder, ok := m.holders[key]; ok {
+		if m.valid(holder) {
+			return holder, m.sessions[holder], true
+		}
+		m.destroy(holder, true) //过期
+	}
+	until, locked = m.delays[key]
+	if locked && time.Now().After(until) {
+		delete(m.delays, key)
+		locked = false
+	}
+	return "", until, locked
+}
+
+func (m *MemoryLockBackend) valid(session string) bool {
+	expire, ok := m.sessions[session]
+	return ok && time.Now().Before(expire)
+}
+
+func (m *MemoryLockBackend) destroy(session string, invalidated bool) {
+	for key, holder := range m.holders {
+		if holder == session {
+			delete(m.holders, key)
+			if invalidated {
+				m.delays[key] = time.Now().Add(m.ttls[session])
+			}
+		}
+	}
+	delete(m.sessions, session)
+	delete(m.ttls, session)
+	m.notify()
+}
+
+func (m *MemoryLockBackend) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
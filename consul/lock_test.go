@@ -0,0 +1,95 @@
+package consul
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+	zt "zlutils/time"
+)
+
+func TestLock(t *testing.T) {
+	backend := NewMemoryLockBackend()
+	config := LockConfig{TTL: zt.Duration{Duration: 100 * time.Millisecond}, Backend: backend}
+	a, b := NewLock("lock", config), NewLock("lock", config)
+	ctx := context.Background()
+
+	lostCtx, err := a.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := b.TryLock(ctx); ok || err != nil {
+		t.Fatalf("get ok %v err %v", ok, err)
+	}
+	time.Sleep(200 * time.Millisecond) //续约后仍然持有
+	if lostCtx.Err() != nil || backend.Holder("lock") == "" {
+		t.Fatal("lock should be held")
+	}
+
+	acquired := make(chan context.Context)
+	go func() {
+		if lostCtx, err := b.Lock(ctx); err == nil {
+			acquired <- lostCtx
+		}
+	}()
+	if err = a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if lostCtx.Err() == nil {
+		t.Error("lostCtx should be canceled after unlock")
+	}
+	select {
+	case lostCtx = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("b should acquire after a unlock")
+	}
+
+	backend.Invalidate("lock")
+	select {
+	case <-lostCtx.Done(): //续约失败
+	case <-time.After(time.Second):
+		t.Error("b should lose lock")
+	}
+}
+
+func TestElection(t *testing.T) {
+	backend := NewMemoryLockBackend()
+	config := LockConfig{TTL: zt.Duration{Duration: 100 * time.Millisecond}, Backend: backend}
+	ctx, cancel := context.WithCancel(context.Background())
+	var leaders, runs int32
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		e := NewElection("leader", config)
+		go func() {
+			defer func() { done <- struct{}{} }()
+			e.Run(ctx, func(ctx context.Context) {
+				if atomic.AddInt32(&leaders, 1) != 1 {
+					t.Error("more than one leader")
+				}
+				atomic.AddInt32(&runs, 1)
+				select {
+				case <-ctx.Done():
+				case <-time.After(50 * time.Millisecond): //主动让出
+				}
+				atomic.AddInt32(&leaders, -1)
+			})
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	backend.Invalidate("leader")
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Run should return after ctx canceled")
+		}
+	}
+	if runs < 2 {
+		t.Errorf("get runs %d", runs)
+	}
+	if backend.Holder("leader") != "" {
+		t.Error("lock should be released on shutdown")
+	}
+}
@@ -0,0 +1,26 @@
+package consul
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func InitDefaultMetric(projectName string) {
+	defaultReload := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_consul_reload_total", projectName),
+			Help: "Total consul watch reload counts",
+		},
+		[]string{"key", "result"},
+	)
+	prometheus.MustRegister(
+		defaultReload,
+	)
+	MetricReload = func(key, result string) prometheus.Counter {
+		return defaultReload.WithLabelValues(key, result)
+	}
+}
+
+var (
+	MetricReload func(key, result string) prometheus.Counter //result: success failure
+)
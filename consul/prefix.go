@@ -0,0 +1,120 @@
+package consul
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+//watch一个前缀下的所有key，每个key一项，例如功能开关、租户配置，
+//mapPtr必须是map[string]T的指针，map的key是去掉前缀后的相对key，
+//每次变化只处理增删改的key，某个key unmarshal或校验失败时保留其旧值（新增的则不加入），不影响其他key，
+//handler在初始化和每次变化后调用，errs为本次失败的key及原因，可以为nil
+func WatchPrefixJson(prefix string, mapPtr interface{}, handler func(errs map[string]error)) {
+	watchPrefix(prefix, mapPtr, handler, defaultConsul, json.Unmarshal)
+}
+
+func WatchPrefixYaml(prefix string, mapPtr interface{}, handler func(errs map[string]error)) {
+	watchPrefix(prefix, mapPtr, handler, defaultConsul, yaml.Unmarshal)
+}
+
+func (m Consul) WatchPrefixJson(prefix string, mapPtr interface{}, handler func(errs map[string]error)) {
+	watchPrefix(prefix, mapPtr, handler, m, json.Unmarshal)
+}
+
+func (m Consul) WatchPrefixYaml(prefix string, mapPtr interface{}, handler func(errs map[string]error)) {
+	watchPrefix(prefix, mapPtr, handler, m, yaml.Unmarshal)
+}
+
+func watchPrefix(prefix string, mapPtr interface{}, handler func(errs map[string]error), lo Consul, unmarshal Unmarshal) {
+	fullPrefix := strings.TrimSuffix(fullKey(prefix, lo), "/") + "/"
+	entry := logrus.WithFields(logrus.Fields{
+		"prefix": fullPrefix,
+		"type":   reflect.TypeOf(mapPtr).String(),
+	})
+	t := reflect.TypeOf(mapPtr)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Map || t.Elem().Key().Kind() != reflect.String {
+		entry.Panic("mapPtr must be ptr of map[string]T")
+	}
+	elemType := t.Elem().Elem()
+	indexes := map[string]uint64{} //已生效的版本，版本相同则跳过
+
+	apply := func(pairs []*Pair) {
+		if lo.locker != nil {
+			lo.locker.Lock()
+			defer lo.locker.Unlock()
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				entry.Errorf("panic: %v", r)
+			}
+		}()
+		mp := reflect.ValueOf(mapPtr).Elem()
+		if mp.IsNil() {
+			mp.Set(reflect.MakeMap(mp.Type()))
+		}
+		var errs map[string]error
+		seen := map[string]bool{}
+		for _, pair := range pairs {
+			k := strings.TrimPrefix(pair.Key, fullPrefix)
+			if k == "" || strings.HasSuffix(k, "/") { //consul中的目录
+				continue
+			}
+			seen[k] = true
+			if index, ok := indexes[k]; ok && pair.Index != 0 && index == pair.Index {
+				continue
+			}
+			entry := entry.WithFields(logrus.Fields{
+				"key": k,
+				"bs":  maskBs(pair.Value, elemType, unmarshal),
+			})
+			var old interface{}
+			if v := mp.MapIndex(reflect.ValueOf(k).Convert(mp.Type().Key())); v.IsValid() {
+				old = mask(v.Interface())
+			}
+			elemPtr := reflect.New(elemType)
+			var newValue interface{} = []byte(maskBs(pair.Value, elemType, unmarshal)) //unmarshal失败时只有原始值
+			value, err := decrypt(pair.Value, unmarshal)
+			if err == nil {
+				err = unmarshal(value, elemPtr.Interface())
+			}
+			if err == nil {
+				newValue = mask(elemPtr.Interface())
+				err = valiVa(lo, elemPtr.Interface())
+			}
+			recordReload(pair.Key, pair.Index, old, newValue, err)
+			if err != nil {
+				if errs == nil {
+					errs = map[string]error{}
+				}
+				errs[k] = err
+				entry.WithError(err).Error("consul watch prefix value invalid")
+				continue
+			}
+			indexes[k] = pair.Index
+			mp.SetMapIndex(reflect.ValueOf(k).Convert(mp.Type().Key()), elemPtr.Elem())
+			entry.WithField("value", mask(elemPtr.Interface())).Info("consul watch prefix value ok")
+		}
+		for _, key := range mp.MapKeys() {
+			if k := key.String(); !seen[k] {
+				mp.SetMapIndex(key, reflect.Value{})
+				delete(indexes, k)
+				entry.WithField("key", k).Info("consul watch prefix value deleted")
+			}
+		}
+		if handler != nil {
+			handler(errs)
+		}
+	}
+
+	pairs, err := lo.getSource().List(fullPrefix)
+	if err != nil {
+		entry.WithError(err).Panic("consul list prefix failed")
+	}
+	apply(pairs)
+	if err = lo.getSource().WatchPrefix(fullPrefix, apply); err != nil {
+		entry.WithError(err).Panic("consul watch prefix failed")
+	}
+}
@@ -0,0 +1,48 @@
+package consul
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWatchPrefixJson(t *testing.T) {
+	src := NewMapSource(map[string]string{
+		"test/flags/a":   `{"d":"1s"}`,
+		"test/flags/b":   `{"d":"2s"}`,
+		"test/flags/bad": `{}`,
+		"test/flagsx/c":  `{"d":"3s"}`,
+	})
+	var flags map[string]Tmp
+	var lastErrs map[string]error
+	WithSource(src).WithPrefix("test").ValiStruct().WatchPrefixJson("flags", &flags, func(errs map[string]error) {
+		lastErrs = errs
+	})
+	get := func() string {
+		m := map[string]string{}
+		for k, v := range flags {
+			m[k] = v.D.String()
+		}
+		return fmt.Sprint(m)
+	}
+	if got := get(); got != "map[a:1s b:2s]" {
+		t.Fatalf("get %s", got)
+	}
+	if len(lastErrs) != 1 || lastErrs["bad"] == nil {
+		t.Errorf("get errs %v", lastErrs)
+	}
+
+	src.Set("test/flags/a", []byte(`{"d":"5s"}`))
+	src.Set("test/flags/b", []byte(`{}`)) //保留旧值
+	src.Delete("test/flags/bad")
+	src.Set("test/flags/c", []byte(`{"d":"6s"}`))
+	if got := get(); got != "map[a:5s b:2s c:6s]" {
+		t.Errorf("get %s", got)
+	}
+	if len(lastErrs) != 1 || lastErrs["b"] == nil {
+		t.Errorf("get errs %v", lastErrs)
+	}
+	src.Delete("test/flags/a")
+	if got := get(); got != "map[b:2s c:6s]" {
+		t.Errorf("get %s", got)
+	}
+}
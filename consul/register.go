@@ -1,75 +1,59 @@
 package consul
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/api/watch"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"time"
+	"zlutils/address"
+	zt "zlutils/time"
 )
 
-//目前只返回ecs的，可手动修改方便调试
-var GetAddress = func() (ip string, port int, err error) {
-	for i := 1; i <= 32; i <<= 1 {
-		time.Sleep(time.Duration(i) * time.Second)
-		ip, port, err = getAddressOnEcs()
-		if err == nil {
-			return
-		}
-	}
-	err = fmt.Errorf("cant get address")
-	logrus.WithError(err).Error()
-	return "", 0, err
-}
+//与health.ReadinessPath相同，health依赖consul，不能直接引用
+const DefaultHealthPath = "/health/ready"
 
-func getAddressOnEcs() (ip string, port int, err error) {
-	ecsMeta := os.Getenv("ECS_CONTAINER_METADATA_FILE")
-	if ecsMeta == "" {
-		err = fmt.Errorf("ecsMeta is empty")
-		logrus.WithError(err).Error()
-		return
-	}
-	entry := logrus.WithField("ecsMeta", ecsMeta)
-	ecsData, err := ioutil.ReadFile(ecsMeta)
-	if err != nil {
-		entry.WithError(err).Warn("read ecs meta file failed")
-		return
-	}
-	entry = entry.WithField("ecsData", string(ecsData))
-
-	var metaData struct {
-		PortMappings []struct {
-			HostPort int `json:"HostPort"`
-		} `json:"PortMappings"`
-		HostPrivateIPv4Address string `json:"HostPrivateIPv4Address"`
-		MetadataFileStatus     string `json:"MetadataFileStatus"`
-	}
-	if err = json.Unmarshal(ecsData, &metaData); err != nil {
-		entry.WithError(err).Error("decode meta json failed")
-		return
-	}
-	entry = entry.WithField("metaData", metaData)
-	if metaData.MetadataFileStatus != "READY" || len(metaData.PortMappings) == 0 {
-		err = fmt.Errorf("hasn't ready")
-		entry.WithError(err).Error()
-		return
-	}
-	entry.Debug()
-	return metaData.HostPrivateIPv4Address, metaData.PortMappings[0].HostPort, nil
-}
+//依次尝试address.Providers，超时见address.Timeout，可手动修改方便调试
+var GetAddress = address.GetWithTimeout
 
 type RegisterConfig struct {
 	//Stage       string `json:"env" validate:"oneof=local dev test prod"`
-	ServiceName                    string `json:"service_name" validate:"required"`
-	MetricsPath                    string `json:"metrics_path" validate:"required"`
-	Interval                       string `json:"interval"`                          //检查间隔，默认5s
-	Timeout                        string `json:"timeout"`                           //超时时间，默认3s
-	FailedFatal                    bool   `json:"failed_fatal"`                      //true:注册失败则fatal
-	DeregisterCriticalServiceAfter string `json:"deregister_critical_service_after"` //多久之后注销
+	ServiceName                    string            `json:"service_name" validate:"required"`
+	MetricsPath                    string            `json:"metrics_path"`                      //不再用于检查，保留兼容旧配置
+	HealthPath                     string            `json:"health_path"`                       //没有配置Checks时用它做http检查，默认DefaultHealthPath
+	Interval                       string            `json:"interval"`                          //检查间隔，默认5s
+	Timeout                        string            `json:"timeout"`                           //超时时间，默认3s
+	FailedFatal                    bool              `json:"failed_fatal"`                      //true:注册失败则fatal
+	DeregisterCriticalServiceAfter string            `json:"deregister_critical_service_after"` //多久之后注销
+	Checks                         []CheckConfig     `json:"checks" validate:"dive"`            //多个检查都通过服务才可用
+	Tags                           []string          `json:"tags"`
+	Meta                           map[string]string `json:"meta"`           //例如version、git_sha、zone，调用方可以按它路由
+	WeightPassing                  int               `json:"weight_passing"` //默认1
+	WeightWarning                  int               `json:"weight_warning"` //默认1
+}
+
+const (
+	CheckHTTP = "http"
+	CheckTCP  = "tcp"
+	CheckGRPC = "grpc"
+	CheckTTL  = "ttl" //由本服务定时上报心跳，Func不为nil时用它的结果作为检查结果，可用于自定义检查
+)
+
+type CheckConfig struct {
+	Type                           string       `json:"type" validate:"oneof=http tcp grpc ttl"`
+	Name                           string       `json:"name"`   //默认为Type，同一服务的检查不能重名
+	Path                           string       `json:"path"`   //http的路径
+	Method                         string       `json:"method"` //http的方法，默认GET
+	Target                         string       `json:"target"` //http、tcp、grpc的host:port，默认本服务地址，grpc可以是host:port/service
+	GRPCUseTLS                     bool         `json:"grpc_use_tls"`
+	Interval                       string       `json:"interval"` //默认RegisterConfig.Interval
+	Timeout                        string       `json:"timeout"`  //默认RegisterConfig.Timeout
+	TTL                            zt.Duration  `json:"ttl"`      //ttl检查多久没有心跳则critical，默认15s，每TTL/3上报一次
+	DeregisterCriticalServiceAfter string       `json:"deregister_critical_service_after"`
+	Func                           func() error `json:"-"` //ttl检查的自定义检查，返回err则上报critical
 }
 
 var serviceIdCh = make(chan string, 1)
@@ -77,6 +61,7 @@ var serviceId string
 var serviceName string
 
 var registration *api.AgentServiceRegistration
+var stopHeartbeat = func() {}
 
 func Register(config RegisterConfig) {
 	entry := logrus.WithField("config", config)
@@ -103,39 +88,135 @@ func Register(config RegisterConfig) {
 		"ip":   ip,
 		"port": port,
 	})
+	registration = newRegistration(config, ip, port)
+	err = Client.Agent().ServiceRegister(registration)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopHeartbeat = cancel
+	for i, check := range config.Checks {
+		if check.Type == CheckTTL {
+			go heartbeat(ctx, registration.Checks[i].CheckID, check, Client.Agent().UpdateTTL)
+		}
+	}
+	serviceId = registration.ID
+	serviceName = config.ServiceName
+	serviceIdCh <- registration.ID
+	entry.Infof("服务注册成功")
+}
+
+func newRegistration(config RegisterConfig, ip string, port int) *api.AgentServiceRegistration {
 	if config.Interval == "" {
 		config.Interval = "5s"
 	}
 	if config.Timeout == "" {
 		config.Timeout = "3s"
 	}
+	if config.WeightPassing == 0 {
+		config.WeightPassing = 1
+	}
+	if config.WeightWarning == 0 {
+		config.WeightWarning = 1
+	}
 	address := fmt.Sprintf("%s:%d", ip, port)
-	registration = &api.AgentServiceRegistration{
+	if len(config.Checks) == 0 {
+		path := config.HealthPath
+		if path == "" {
+			path = DefaultHealthPath
+		}
+		config.Checks = []CheckConfig{{Type: CheckHTTP, Path: path}}
+	}
+	registration := &api.AgentServiceRegistration{
 		Name:    config.ServiceName,
 		ID:      address,
 		Port:    port,
 		Address: ip,
-		Check: &api.AgentServiceCheck{
-			Interval:                       config.Interval,
-			Timeout:                        config.Timeout,
-			Method:                         http.MethodGet,
-			HTTP:                           fmt.Sprintf("http://%s%s", address, config.MetricsPath),
-			DeregisterCriticalServiceAfter: config.DeregisterCriticalServiceAfter,
-			Status:                         api.HealthPassing,
+		Tags:    config.Tags,
+		Meta:    config.Meta,
+		Weights: &api.AgentWeights{
+			Passing: config.WeightPassing,
+			Warning: config.WeightWarning,
 		},
 	}
-	err = Client.Agent().ServiceRegister(registration)
-	if err != nil {
-		return
+	for _, check := range config.Checks {
+		if check.Name == "" {
+			check.Name = check.Type
+		}
+		if check.Target == "" {
+			check.Target = address
+		}
+		if check.Interval == "" {
+			check.Interval = config.Interval
+		}
+		if check.Timeout == "" {
+			check.Timeout = config.Timeout
+		}
+		if check.DeregisterCriticalServiceAfter == "" {
+			check.DeregisterCriticalServiceAfter = config.DeregisterCriticalServiceAfter
+		}
+		c := &api.AgentServiceCheck{
+			CheckID:                        fmt.Sprintf("%s:%s", address, check.Name),
+			Name:                           check.Name,
+			DeregisterCriticalServiceAfter: check.DeregisterCriticalServiceAfter,
+			Status:                         api.HealthPassing,
+		}
+		switch check.Type {
+		case CheckHTTP:
+			c.Method = check.Method
+			if c.Method == "" {
+				c.Method = http.MethodGet
+			}
+			c.HTTP = fmt.Sprintf("http://%s%s", check.Target, check.Path)
+		case CheckTCP:
+			c.TCP = check.Target
+		case CheckGRPC:
+			c.GRPC = check.Target
+			c.GRPCUseTLS = check.GRPCUseTLS
+		case CheckTTL:
+			c.TTL = ttlOf(check).String()
+		}
+		if check.Type != CheckTTL {
+			c.Interval = check.Interval
+			c.Timeout = check.Timeout
+		}
+		registration.Checks = append(registration.Checks, c)
+	}
+	return registration
+}
+
+func ttlOf(check CheckConfig) time.Duration {
+	if check.TTL.Duration == 0 {
+		return 15 * time.Second
+	}
+	return check.TTL.Duration
+}
+
+//每TTL/3上报一次，直到ctx结束
+func heartbeat(ctx context.Context, checkId string, check CheckConfig, updateTTL func(checkId, output, status string) error) {
+	ticker := time.NewTicker(ttlOf(check) / 3)
+	defer ticker.Stop()
+	for {
+		status, output := api.HealthPassing, ""
+		if check.Func != nil {
+			if err := check.Func(); err != nil {
+				status, output = api.HealthCritical, err.Error()
+			}
+		}
+		if err := updateTTL(checkId, output, status); err != nil {
+			logrus.WithField("checkId", checkId).WithError(err).Warn("update ttl failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	serviceId = address
-	serviceName = config.ServiceName
-	serviceIdCh <- address
-	entry.Infof("服务注册成功")
 }
 
 func DRegister() {
 	serviceId := <-serviceIdCh
+	stopHeartbeat()
 	entry := logrus.WithField("serviceId", serviceId)
 	if err := Client.Agent().ServiceDeregister(serviceId); err != nil {
 		entry.WithError(err).Error("服务注销失败")
@@ -144,6 +225,39 @@ func DRegister() {
 	entry.Info("服务注销成功")
 }
 
+//不阻塞的DRegister，没有注册成功时什么也不做
+func Deregister() error {
+	return DeregisterCtx(context.Background())
+}
+
+//与Deregister相同，ctx结束时放弃请求，避免consul不可用时阻塞退出
+func DeregisterCtx(ctx context.Context) error {
+	select {
+	case <-serviceIdCh: //避免之后DRegister重复注销
+	default:
+	}
+	if serviceId == "" {
+		return nil
+	}
+	stopHeartbeat()
+	entry := logrus.WithField("serviceId", serviceId)
+	//Agent().ServiceDeregister不支持ctx
+	if _, err := Client.Raw().Write("/v1/agent/service/deregister/"+serviceId, nil, nil, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+		entry.WithError(err).Error("服务注销失败")
+		return err
+	}
+	entry.Info("服务注销成功")
+	return nil
+}
+
+//进入维护状态，consul不再把流量导到本实例，没有注册成功时什么也不做
+func EnableMaintenance(reason string) error {
+	if serviceId == "" {
+		return nil
+	}
+	return Client.Agent().EnableServiceMaintenance(serviceId, reason)
+}
+
 type WatchChecksCallback func(heathChecks []*api.HealthCheck) (err error)
 
 // watch check
@@ -165,7 +279,7 @@ func WatchChecks(serviceName string, callback WatchChecksCallback) (err error) {
 
 		errCallback := callback(healthChecks)
 		if errCallback != nil {
-			logrus.Errorf("watch checks callback error: %s", err)
+			logrus.Errorf("watch checks callback error: %s", errCallback)
 			return
 		}
 	}
@@ -173,7 +287,7 @@ func WatchChecks(serviceName string, callback WatchChecksCallback) (err error) {
 	go func() {
 		errRun := plan.Run(Address)
 		if nil != errRun {
-			logrus.Errorf("error: %s", err)
+			logrus.Errorf("error: %s", errRun)
 		}
 	}()
 	return
@@ -204,7 +318,7 @@ func WatchService(
 
 		errCallback := callback(serviceEntries)
 		if errCallback != nil {
-			logrus.Errorf("do watch service callback error: %s", err)
+			logrus.Errorf("do watch service callback error: %s", errCallback)
 			return
 		}
 	}
@@ -212,7 +326,7 @@ func WatchService(
 	go func() {
 		errRun := plan.Run(Address)
 		if nil != errRun {
-			logrus.Errorf("error: %s", err)
+			logrus.Errorf("error: %s", errRun)
 		}
 	}()
 
@@ -255,7 +369,7 @@ type MaintenanceCallbackIn struct {
 	EnableReason map[string]interface{} `json:"enable_reason"`
 }
 
-//不与code关联
+//不与code关联，会修改服务名，需要鉴权、幂等、失败回滚时用NewAdmin
 func ServiceMaintenanceHandler(callback func(*MaintenanceCallbackIn) error) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var reqBody MaintenanceCallbackIn
@@ -1,15 +1,19 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/hashicorp/consul/api"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
+	zt "zlutils/time"
 )
 
 func TestRegister(t *testing.T) {
@@ -67,3 +71,119 @@ func TestServiceMaintenanceHandler(t *testing.T) {
 	})
 	r.Run(":12345")
 }
+
+func TestNewRegistration(t *testing.T) {
+	config := RegisterConfig{
+		ServiceName: "hello",
+		MetricsPath: "/metrics",
+	}
+	if err := vali.Struct(config); err != nil {
+		t.Fatal(err)
+	}
+	r := newRegistration(config, "10.0.0.1", 80) //默认检查readiness，不再检查metrics
+	if len(r.Checks) != 1 || r.Checks[0].HTTP != "http://10.0.0.1:80/health/ready" || r.Checks[0].Interval != "5s" {
+		t.Errorf("get %+v", r.Checks)
+	}
+
+	config = RegisterConfig{
+		ServiceName: "hello",
+		Tags:        []string{"v2"},
+		Meta:        map[string]string{"version": "2.0.0"},
+		Checks: []CheckConfig{
+			{Type: CheckHTTP, Path: "/health/ready"},
+			{Type: CheckTCP},
+			{Type: CheckGRPC, Target: "10.0.0.1:9090/hello"},
+			{Type: CheckTTL, Name: "worker"},
+		},
+		WeightPassing: 10,
+	}
+	if err := vali.Struct(config); err != nil {
+		t.Fatal(err)
+	}
+	r = newRegistration(config, "10.0.0.1", 80)
+	if len(r.Checks) != 4 || r.Tags[0] != "v2" || r.Meta["version"] != "2.0.0" || r.Weights.Passing != 10 || r.Weights.Warning != 1 {
+		t.Fatalf("get %+v", r)
+	}
+	if c := r.Checks[1]; c.TCP != "10.0.0.1:80" || c.CheckID != "10.0.0.1:80:tcp" {
+		t.Errorf("get %+v", c)
+	}
+	if c := r.Checks[3]; c.TTL != "15s" || c.Interval != "" || c.CheckID != "10.0.0.1:80:worker" {
+		t.Errorf("get %+v", c)
+	}
+	if err := vali.Struct(RegisterConfig{ServiceName: "hello"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	statuses := make(chan string, 10)
+	fail := false
+	check := CheckConfig{
+		Type: CheckTTL,
+		TTL:  zt.Duration{Duration: 30 * time.Millisecond},
+		Func: func() error {
+			if fail {
+				return fmt.Errorf("worker stuck")
+			}
+			fail = true
+			return nil
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		heartbeat(ctx, "id", check, func(checkId, output, status string) error {
+			statuses <- status
+			return nil
+		})
+		close(done)
+	}()
+	if s := <-statuses; s != api.HealthPassing {
+		t.Errorf("get %s", s)
+	}
+	if s := <-statuses; s != api.HealthCritical {
+		t.Errorf("get %s", s)
+	}
+	cancel()
+	<-done
+}
+
+func TestNewRegistrationHealthPath(t *testing.T) {
+	config := RegisterConfig{ServiceName: "hello", HealthPath: "/ready"}
+	if err := vali.Struct(config); err != nil {
+		t.Fatal(err)
+	}
+	if r := newRegistration(config, "10.0.0.1", 80); r.Checks[0].HTTP != "http://10.0.0.1:80/ready" {
+		t.Errorf("get %+v", r.Checks[0])
+	}
+}
+
+func TestDeregisterCtx(t *testing.T) {
+	paths := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		<-r.Context().Done() //consul不可用时一直不响应
+	}))
+	defer ts.Close()
+	client, err := api.NewClient(&api.Config{Address: strings.TrimPrefix(ts.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(c *api.Client, id string) {
+		Client, serviceId = c, id
+	}(Client, serviceId)
+	Client, serviceId = client, "10.0.0.1:80"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := DeregisterCtx(ctx); err == nil {
+		t.Error("want err")
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("blocked %s", d)
+	}
+	if path := <-paths; path != "/v1/agent/service/deregister/10.0.0.1:80" {
+		t.Errorf("get path %s", path)
+	}
+}
@@ -0,0 +1,286 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	zt "zlutils/time"
+)
+
+//服务发现依赖的查询，默认用Client，单测时可替换
+type ServiceBackend interface {
+	//阻塞查询，阻塞到index大于waitIndex或超时，返回服务的所有实例
+	Service(ctx context.Context, service string, waitIndex uint64) (entries []*api.ServiceEntry, index uint64, err error)
+}
+
+type RegistryConfig struct {
+	RetryInterval zt.Duration    `json:"retry_interval"` //consul出错时多久后重试，默认5s，期间使用最后一次成功的结果
+	Backend       ServiceBackend `json:"-"`              //默认用Client
+}
+
+//一个健康的服务实例
+type Instance struct {
+	ID      string            `json:"id"`
+	Service string            `json:"service"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags"`
+	Meta    map[string]string `json:"meta"`
+	Weight  int               `json:"weight"` //Weights.Passing，最小为1
+}
+
+func (m Instance) Host() string {
+	return m.Address + ":" + strconv.Itoa(m.Port)
+}
+
+//包含所有tag
+func (m Instance) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		if !hasTag(m.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+//服务发现，在内存中维护服务的健康实例，通过consul阻塞查询更新，
+//consul不可用时继续使用最后一次的结果
+type Registry struct {
+	config   RegistryConfig
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	services map[string]*registryService
+}
+
+type registryService struct {
+	ready       chan struct{} //收到第一次结果后关闭
+	instances   []Instance
+	updatedAt   time.Time
+	err         error //最后一次查询的错误，成功后清空
+	next        int
+	subscribers map[int]func(instances []Instance)
+	nextSub     int
+}
+
+func NewRegistry(config RegistryConfig) *Registry {
+	if config.RetryInterval.Duration == 0 {
+		config.RetryInterval.Duration = 5 * time.Second
+	}
+	if config.Backend == nil {
+		config.Backend = clientServiceBackend{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		config:   config,
+		ctx:      ctx,
+		cancel:   cancel,
+		services: map[string]*registryService{},
+	}
+}
+
+//停止所有watch
+func (m *Registry) Close() {
+	m.cancel()
+}
+
+//返回带有所有tags的健康实例，第一次调用时开始watch该服务，并阻塞到收到第一次结果或ctx结束
+func (m *Registry) Get(ctx context.Context, service string, tags ...string) ([]Instance, error) {
+	s := m.getService(service)
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		m.mu.Lock()
+		err := s.err
+		m.mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("%s, last err: %s", ctx.Err(), err)
+		}
+		return nil, ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return filterInstances(s.instances, tags), nil
+}
+
+//轮询选取一个带有所有tags的健康实例
+func (m *Registry) Pick(ctx context.Context, service string, tags ...string) (Instance, error) {
+	instances, err := m.Get(ctx, service, tags...)
+	if err != nil {
+		return Instance{}, err
+	}
+	if len(instances) == 0 {
+		return Instance{}, fmt.Errorf("service %s has no healthy instance with tags %v", service, tags)
+	}
+	s := m.getService(service)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.next++
+	return instances[s.next%len(instances)], nil
+}
+
+//实例发生变化时调用fn，已经有结果时立即调用一次，返回的函数用于取消订阅，
+//fn在持有锁时调用，不能阻塞，也不能调用Registry的方法
+func (m *Registry) Subscribe(service string, fn func(instances []Instance)) (unsubscribe func()) {
+	s := m.getService(service)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := s.nextSub
+	s.nextSub++
+	s.subscribers[id] = fn
+	select {
+	case <-s.ready:
+		fn(s.instances)
+	default:
+	}
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+//最后一次成功更新的时间，以及之后查询的错误，用于判断数据是否过时
+func (m *Registry) Status(service string) (updatedAt time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.services[service]
+	if !ok {
+		return time.Time{}, fmt.Errorf("service %s not watched", service)
+	}
+	return s.updatedAt, s.err
+}
+
+func (m *Registry) getService(service string) *registryService {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.services[service]
+	if ok {
+		return s
+	}
+	s = &registryService{
+		ready:       make(chan struct{}),
+		subscribers: map[int]func(instances []Instance){},
+	}
+	m.services[service] = s
+	go m.watch(service)
+	return s
+}
+
+func (m *Registry) watch(service string) {
+	entry := logrus.WithField("service", service)
+	var index uint64
+	for {
+		entries, newIndex, err := m.config.Backend.Service(m.ctx, service, index)
+		if m.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			m.mu.Lock()
+			m.services[service].err = err
+			m.mu.Unlock()
+			entry.WithError(err).Warn("registry watch service failed, use last known instances")
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(m.config.RetryInterval.Duration):
+			}
+			continue
+		}
+		if newIndex < index { //consul重启等导致index重置
+			newIndex = 0
+		}
+		index = newIndex
+		m.update(service, entries)
+	}
+}
+
+//用查询到的健康实例替换，没有变化时不通知订阅者
+func (m *Registry) update(service string, entries []*api.ServiceEntry) {
+	var instances []Instance
+	for _, entry := range entries {
+		if entry.Service == nil || entry.Checks.AggregatedStatus() != api.HealthPassing {
+			continue
+		}
+		address := entry.Service.Address
+		if address == "" && entry.Node != nil {
+			address = entry.Node.Address
+		}
+		weight := entry.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		instances = append(instances, Instance{
+			ID:      entry.Service.ID,
+			Service: entry.Service.Service,
+			Address: address,
+			Port:    entry.Service.Port,
+			Tags:    entry.Service.Tags,
+			Meta:    entry.Service.Meta,
+			Weight:  weight,
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Host() < instances[j].Host()
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.services[service]
+	s.updatedAt = time.Now()
+	s.err = nil
+	select {
+	case <-s.ready:
+		if reflect.DeepEqual(s.instances, instances) {
+			return
+		}
+	default:
+		close(s.ready)
+	}
+	s.instances = instances
+	logrus.WithFields(logrus.Fields{
+		"service":   service,
+		"instances": len(instances),
+	}).Info("registry service updated")
+	for _, fn := range s.subscribers {
+		fn(instances)
+	}
+}
+
+func filterInstances(instances []Instance, tags []string) []Instance {
+	if len(tags) == 0 {
+		return instances
+	}
+	var filtered []Instance
+	for _, ins := range instances {
+		if ins.HasTags(tags...) {
+			filtered = append(filtered, ins)
+		}
+	}
+	return filtered
+}
+
+type clientServiceBackend struct{}
+
+func (clientServiceBackend) Service(ctx context.Context, service string, waitIndex uint64) ([]*api.ServiceEntry, uint64, error) {
+	entries, meta, err := Client.Health().Service(service, "", true, (&api.QueryOptions{WaitIndex: waitIndex}).WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, meta.LastIndex, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"sync"
+	"testing"
+	"time"
+	zt "zlutils/time"
+)
+
+//每次查询从ch中取一个结果
+type fakeServiceBackend struct {
+	ch chan fakeServiceResult
+}
+
+type fakeServiceResult struct {
+	entries []*api.ServiceEntry
+	err     error
+}
+
+func (m fakeServiceBackend) Service(ctx context.Context, service string, waitIndex uint64) ([]*api.ServiceEntry, uint64, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case r := <-m.ch:
+		return r.entries, waitIndex + 1, r.err
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	backend := fakeServiceBackend{ch: make(chan fakeServiceResult)}
+	r := NewRegistry(RegistryConfig{
+		RetryInterval: zt.Duration{Duration: time.Millisecond},
+		Backend:       backend,
+	})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Get(ctx, "s"); err != context.DeadlineExceeded { //还没有结果
+		t.Fatalf("get err %v", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	unsubscribe := r.Subscribe("s", func(instances []Instance) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, fmt.Sprint(len(instances)))
+	})
+	a := newEntry("a", 1, 1, api.HealthPassing)
+	a.Service.Tags = []string{"v1", "canary"}
+	b := newEntry("b", 1, 1, api.HealthPassing)
+	b.Service.Tags = []string{"v1"}
+	c := newEntry("c", 1, 1, api.HealthCritical)
+	backend.ch <- fakeServiceResult{entries: []*api.ServiceEntry{b, a, c}}
+
+	ctx = context.Background()
+	instances, err := r.Get(ctx, "s", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[0].Host() != "a:1" || instances[1].Host() != "b:1" {
+		t.Errorf("get %v", instances)
+	}
+	for i := 0; i < 2; i++ {
+		if ins, err := r.Pick(ctx, "s", "canary"); err != nil || ins.Host() != "a:1" {
+			t.Errorf("get %v %v", ins, err)
+		}
+	}
+	if _, err := r.Pick(ctx, "s", "v2"); err == nil {
+		t.Error("want err")
+	}
+
+	//consul不可用时保留最后的结果
+	backend.ch <- fakeServiceResult{err: fmt.Errorf("connection refused")}
+	backend.ch <- fakeServiceResult{entries: []*api.ServiceEntry{a, b, c}} //没有变化
+	backend.ch <- fakeServiceResult{err: fmt.Errorf("connection refused")}
+	backend.ch <- fakeServiceResult{err: fmt.Errorf("connection refused")} //确保上一次的err已经记录
+	if _, err := r.Status("s"); err == nil {
+		t.Error("want err")
+	}
+	if instances, _ := r.Get(ctx, "s"); len(instances) != 2 {
+		t.Errorf("get %v", instances)
+	}
+
+	backend.ch <- fakeServiceResult{entries: []*api.ServiceEntry{a}}
+	unsubscribe()
+	backend.ch <- fakeServiceResult{entries: nil}
+	backend.ch <- fakeServiceResult{entries: nil} //确保上一次已经处理完
+	if instances, _ := r.Get(ctx, "s"); len(instances) != 0 {
+		t.Errorf("get %v", instances)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(changes); got != "[2 1]" {
+		t.Errorf("get changes %s", got)
+	}
+}
@@ -0,0 +1,222 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+	zt "zlutils/time"
+)
+
+const (
+	BalanceRoundRobin   = "round_robin"   //轮询
+	BalanceLeastPending = "least_pending" //最少未完成请求
+	BalanceWeighted     = "weighted"      //按consul中设置的Weights.Passing加权轮询
+)
+
+//用于consul配置
+type ResolverConfig struct {
+	Balance     string      `json:"balance" validate:"omitempty,oneof=round_robin least_pending weighted"` //默认round_robin
+	Tag         string      `json:"tag"`                                                                   //只用带该tag的实例
+	MaxFails    int         `json:"max_fails"`                                                             //连续失败多少次后临时摘除，0则不摘除
+	FailTimeout zt.Duration `json:"fail_timeout"`                                                          //摘除多久，默认10s
+	//第一次请求某个服务时，等待consul返回实例的超时，默认10s
+	ReadyTimeout zt.Duration `json:"ready_timeout"`
+	Registry     *Registry   `json:"-"` //实例从这里获取，默认新建一个，也可以与直接使用Registry的代码共用
+}
+
+//实现了request.Resolver，用法：request.RegisterResolver("consul", consul.NewResolver(config))
+//之后url写成 consul://service-name/path 即可
+//实例的watch、健康检查过滤由Registry负责，这里只维护负载均衡和摘除的状态
+type Resolver struct {
+	config   ResolverConfig
+	registry *Registry
+	mu       sync.Mutex
+	services map[string]*resolverService
+}
+
+type resolverService struct {
+	ready     chan struct{} //收到第一次结果后关闭
+	instances []*resolverInstance
+	next      int
+}
+
+type resolverInstance struct {
+	host          string
+	weight        int
+	currentWeight int //平滑加权轮询用
+	pending       int
+	fails         int
+	ejectedUntil  time.Time
+}
+
+func NewResolver(config ResolverConfig) *Resolver {
+	if err := vali.Struct(config); err != nil {
+		logrus.WithField("config", config).WithError(err).Panic("invalid resolver config")
+	}
+	if config.Balance == "" {
+		config.Balance = BalanceRoundRobin
+	}
+	if config.FailTimeout.Duration == 0 {
+		config.FailTimeout.Duration = 10 * time.Second
+	}
+	if config.ReadyTimeout.Duration == 0 {
+		config.ReadyTimeout.Duration = 10 * time.Second
+	}
+	registry := config.Registry
+	if registry == nil {
+		registry = NewRegistry(RegistryConfig{})
+	}
+	return &Resolver{
+		config:   config,
+		registry: registry,
+		services: map[string]*resolverService{},
+	}
+}
+
+//停止watch，Registry是传入的时候不关闭
+func (m *Resolver) Close() {
+	if m.config.Registry == nil {
+		m.registry.Close()
+	}
+}
+
+func (m *Resolver) Resolve(ctx context.Context, service string) (host string, done func(err error), err error) {
+	s := m.getService(service)
+	timer := time.NewTimer(m.config.ReadyTimeout.Duration)
+	defer timer.Stop()
+	select {
+	case <-s.ready:
+	case <-timer.C: //Registry会继续重试，下次请求可能就有结果了
+		err = fmt.Errorf("service %s not ready in %s", service, m.config.ReadyTimeout.Duration)
+		if _, e := m.registry.Status(service); e != nil {
+			err = fmt.Errorf("%s, last err: %s", err, e)
+		}
+		return "", nil, err
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ins := m.pick(s)
+	if ins == nil {
+		return "", nil, fmt.Errorf("service %s has no healthy instance", service)
+	}
+	ins.pending++
+	var once sync.Once
+	done = func(err error) {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			ins.pending--
+			if err == nil {
+				ins.fails = 0
+				return
+			}
+			ins.fails++
+			if m.config.MaxFails > 0 && ins.fails >= m.config.MaxFails {
+				ins.fails = 0
+				ins.ejectedUntil = time.Now().Add(m.config.FailTimeout.Duration)
+				logrus.WithFields(logrus.Fields{
+					"service": service,
+					"host":    ins.host,
+				}).WithError(err).Warn("instance ejected")
+			}
+		})
+	}
+	return ins.host, done, nil
+}
+
+func (m *Resolver) getService(service string) *resolverService {
+	m.mu.Lock()
+	s, ok := m.services[service]
+	if !ok {
+		s = &resolverService{ready: make(chan struct{})}
+		m.services[service] = s
+	}
+	m.mu.Unlock()
+	if !ok { //Subscribe可能立即调用update，所以不能持有锁
+		m.registry.Subscribe(service, func(instances []Instance) {
+			m.update(service, s, instances)
+		})
+	}
+	return s
+}
+
+//用Registry中的健康实例替换，已有实例保留其统计状态
+func (m *Resolver) update(service string, s *resolverService, instances []Instance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := map[string]*resolverInstance{}
+	for _, ins := range s.instances {
+		old[ins.host] = ins
+	}
+	var resolved []*resolverInstance
+	for _, instance := range instances {
+		if m.config.Tag != "" && !instance.HasTags(m.config.Tag) {
+			continue
+		}
+		host := instance.Host()
+		ins, ok := old[host]
+		if !ok {
+			ins = &resolverInstance{host: host}
+		}
+		ins.weight = instance.Weight
+		resolved = append(resolved, ins)
+	}
+	s.instances = resolved
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	logrus.WithFields(logrus.Fields{
+		"service":   service,
+		"instances": len(resolved),
+	}).Info("resolver service updated")
+}
+
+//调用时已加锁
+func (m *Resolver) pick(s *resolverService) *resolverInstance {
+	now := time.Now()
+	var available []*resolverInstance
+	for _, ins := range s.instances {
+		if now.After(ins.ejectedUntil) {
+			available = append(available, ins)
+		}
+	}
+	if len(available) == 0 {
+		available = s.instances //全被摘除时，死马当活马医
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	s.next++
+	switch m.config.Balance {
+	case BalanceLeastPending:
+		var best *resolverInstance
+		for i := range available {
+			ins := available[(s.next+i)%len(available)] //相同时轮询
+			if best == nil || ins.pending < best.pending {
+				best = ins
+			}
+		}
+		return best
+	case BalanceWeighted:
+		var best *resolverInstance
+		total := 0
+		for _, ins := range available {
+			ins.currentWeight += ins.weight
+			total += ins.weight
+			if best == nil || ins.currentWeight > best.currentWeight {
+				best = ins
+			}
+		}
+		best.currentWeight -= total
+		return best
+	default:
+		return available[s.next%len(available)]
+	}
+}
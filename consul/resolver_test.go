@@ -0,0 +1,138 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"zlutils/request"
+	zt "zlutils/time"
+)
+
+func newEntry(address string, port, weight int, status string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{
+			Address: address,
+			Port:    port,
+			Weights: api.AgentWeights{Passing: weight},
+		},
+		Checks: api.HealthChecks{{Status: status}},
+	}
+}
+
+//第一次查询返回entries，之后阻塞
+type staticServiceBackend []*api.ServiceEntry
+
+func (m staticServiceBackend) Service(ctx context.Context, service string, waitIndex uint64) ([]*api.ServiceEntry, uint64, error) {
+	if waitIndex == 0 {
+		return m, 1, nil
+	}
+	<-ctx.Done()
+	return nil, 0, ctx.Err()
+}
+
+func newTestResolver(config ResolverConfig, entries ...*api.ServiceEntry) *Resolver {
+	config.Registry = NewRegistry(RegistryConfig{Backend: staticServiceBackend(entries)})
+	return NewResolver(config)
+}
+
+func TestResolverBalance(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		balance string
+		weightA int
+		want    string
+	}{
+		{BalanceRoundRobin, 3, "map[a:1:2 b:1:2]"},
+		{BalanceWeighted, 3, "map[a:1:3 b:1:1]"},
+	} {
+		r := newTestResolver(ResolverConfig{Balance: test.balance},
+			newEntry("a", 1, test.weightA, api.HealthPassing),
+			newEntry("b", 1, 1, api.HealthPassing),
+			newEntry("c", 1, 1, api.HealthCritical),
+		)
+		defer r.registry.Close()
+		count := map[string]int{}
+		for i := 0; i < 4; i++ {
+			host, done, err := r.Resolve(ctx, "s")
+			if err != nil {
+				t.Fatal(err)
+			}
+			done(nil)
+			count[host]++
+		}
+		if got := fmt.Sprint(count); got != test.want {
+			t.Errorf("%s get %s want %s", test.balance, got, test.want)
+		}
+	}
+}
+
+func TestResolverEject(t *testing.T) {
+	ctx := context.Background()
+	r := newTestResolver(ResolverConfig{Balance: BalanceLeastPending, MaxFails: 1},
+		newEntry("a", 1, 1, api.HealthPassing),
+		newEntry("b", 1, 1, api.HealthPassing),
+	)
+	defer r.registry.Close()
+	bad, done, _ := r.Resolve(ctx, "s")
+	done(fmt.Errorf("timeout"))
+	for i := 0; i < 4; i++ {
+		host, done, _ := r.Resolve(ctx, "s")
+		done(nil)
+		if host == bad {
+			t.Fatalf("ejected %s picked", bad)
+		}
+	}
+}
+
+func TestResolverNotReady(t *testing.T) {
+	backend := fakeServiceBackend{ch: make(chan fakeServiceResult, 1)}
+	backend.ch <- fakeServiceResult{err: fmt.Errorf("connection refused")}
+	r := NewResolver(ResolverConfig{
+		ReadyTimeout: zt.Duration{Duration: 50 * time.Millisecond},
+		Registry:     NewRegistry(RegistryConfig{Backend: backend, RetryInterval: zt.Duration{Duration: time.Millisecond}}),
+	})
+	defer r.config.Registry.Close() //传入的Registry由调用者关闭
+	if _, _, err := r.Resolve(context.Background(), "s"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("get err %v", err)
+	}
+
+	//consul恢复后可用
+	backend.ch <- fakeServiceResult{entries: []*api.ServiceEntry{newEntry("a", 1, 1, api.HealthPassing)}}
+	if host, _, err := r.Resolve(context.Background(), "s"); err != nil || host != "a:1" {
+		t.Errorf("get %s %v", host, err)
+	}
+}
+
+func TestResolverRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"ret":0,"msg":"%s"}`, r.URL.Path)
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	i := strings.LastIndex(host, ":")
+	var port int
+	fmt.Sscan(host[i+1:], &port)
+
+	v1 := newEntry(host[:i], port, 1, api.HealthPassing)
+	v1.Service.Tags = []string{"v1"}
+	r := newTestResolver(ResolverConfig{Tag: "v1"}, newEntry("b", 1, 1, api.HealthPassing), v1) //只用带v1的
+	defer r.registry.Close()
+	request.RegisterResolver("consul", r)
+
+	req := request.Request{Config: request.Config{
+		Method: http.MethodGet,
+		Url:    "consul://hello/world",
+	}}
+	var resp request.RespRet
+	if err := req.Do(context.Background(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Msg != "/world" {
+		t.Errorf("get %s want /world", resp.Msg)
+	}
+}
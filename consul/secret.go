@@ -0,0 +1,261 @@
+package consul
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	EncPrefix = "enc:" //加密的值，enc:base64(nonce+密文)，AES-GCM
+	Masked    = "******"
+
+	EnvSecretKey     = "CONSUL_SECRET_KEY"      //base64的密钥，16、24、32字节分别对应AES-128、192、256
+	EnvSecretKeyFile = "CONSUL_SECRET_KEY_FILE" //密钥文件，内容同上
+)
+
+var secret struct {
+	sync.Mutex
+	aead cipher.AEAD
+}
+
+//设置解密的密钥，不设置时在第一次遇到加密值时从环境变量EnvSecretKey或EnvSecretKeyFile读取
+func SetSecretKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	secret.Lock()
+	defer secret.Unlock()
+	secret.aead = aead
+	return nil
+}
+
+//用于生成写入consul的值，整个值或json中的某个字符串都可以是加密的
+func Encrypt(plaintext []byte) (string, error) {
+	aead, err := getAEAD()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return EncPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func Decrypt(s string) ([]byte, error) {
+	aead, err := getAEAD()
+	if err != nil {
+		return nil, err
+	}
+	bs, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, EncPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %s", err)
+	}
+	if len(bs) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted value: too short")
+	}
+	return aead.Open(nil, bs[:aead.NonceSize()], bs[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func getAEAD() (cipher.AEAD, error) {
+	secret.Lock()
+	defer secret.Unlock()
+	if secret.aead != nil {
+		return secret.aead, nil
+	}
+	encoded, ok := os.LookupEnv(EnvSecretKey)
+	if !ok {
+		path, ok := os.LookupEnv(EnvSecretKeyFile)
+		if !ok {
+			return nil, fmt.Errorf("secret key not set, call SetSecretKey or set env %s or %s", EnvSecretKey, EnvSecretKeyFile)
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(bs)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %s", err)
+	}
+	if secret.aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+	return secret.aead, nil
+}
+
+//整个值以EncPrefix开头时解密整个值，unmarshal不为nil时再解密其中以EncPrefix开头的字符串，
+//后者解密后转成json（yaml兼容json），没有加密值时原样返回
+func decrypt(value []byte, unmarshal Unmarshal) ([]byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(value), []byte(EncPrefix)) {
+		plain, err := Decrypt(string(bytes.TrimSpace(value)))
+		if err != nil {
+			return nil, err
+		}
+		value = plain
+	}
+	if unmarshal == nil || !bytes.Contains(value, []byte(EncPrefix)) {
+		return value, nil
+	}
+	var generic interface{}
+	if err := unmarshalGeneric(value, &generic, unmarshal); err != nil {
+		return value, nil //交给后面的unmarshal报错
+	}
+	generic, err := decryptGeneric(normalize(generic))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+func decryptGeneric(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.HasPrefix(v, EncPrefix) {
+			return v, nil
+		}
+		plain, err := Decrypt(v)
+		return string(plain), err
+	case map[string]interface{}:
+		for k, e := range v {
+			d, err := decryptGeneric(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err)
+			}
+			v[k] = d
+		}
+	case []interface{}:
+		for i, e := range v {
+			d, err := decryptGeneric(e)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", i, err)
+			}
+			v[i] = d
+		}
+	}
+	return v, nil
+}
+
+//用于打日志，把带有secret tag的字段替换成Masked，例如
+//	Url string `json:"url" secret:"true"`
+//返回的是json的通用结构，没有secret字段时原样返回
+func mask(v interface{}) interface{} {
+	if v == nil || !hasSecret(reflect.TypeOf(v), nil) {
+		return v
+	}
+	generic := toGeneric(v)
+	maskGeneric(reflect.TypeOf(v), generic)
+	return generic
+}
+
+//用于打日志的原始值，t是要unmarshal成的类型，没有secret字段时原样返回，无法解析时整个隐藏
+func maskBs(value []byte, t reflect.Type, unmarshal Unmarshal) string {
+	if !hasSecret(t, nil) {
+		return string(value)
+	}
+	var generic interface{}
+	if err := unmarshalGeneric(value, &generic, unmarshal); err != nil {
+		return Masked
+	}
+	generic = normalize(generic)
+	maskGeneric(t, generic)
+	bs, _ := json.Marshal(generic)
+	return string(bs)
+}
+
+func hasSecret(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	if seen == nil {
+		seen = map[reflect.Type]bool{}
+	}
+	seen[t] = true //避免递归类型死循环
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("secret") == "true" || hasSecret(f.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskGeneric(t reflect.Type, generic interface{}) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if list, ok := generic.([]interface{}); ok {
+			for _, e := range list {
+				maskGeneric(t.Elem(), e)
+			}
+		}
+	case reflect.Map:
+		if mp, ok := generic.(map[string]interface{}); ok {
+			for _, e := range mp {
+				maskGeneric(t.Elem(), e)
+			}
+		}
+	case reflect.Struct:
+		mp, ok := generic.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := tagName(f, "json")
+			if name == "-" {
+				continue
+			}
+			if f.Anonymous && name == "" { //嵌入的结构体字段是平铺的
+				maskGeneric(f.Type, generic)
+				continue
+			}
+			if name == "" {
+				name = tagName(f, "yaml")
+			}
+			if name == "" {
+				name = f.Name
+			}
+			for k := range mp {
+				if !strings.EqualFold(k, name) { //json unmarshal时不区分大小写
+					continue
+				}
+				if f.Tag.Get("secret") == "true" {
+					mp[k] = Masked
+				} else {
+					maskGeneric(f.Type, mp[k])
+				}
+			}
+		}
+	}
+}
+
+func tagName(f reflect.StructField, key string) string {
+	return strings.Split(f.Tag.Get(key), ",")[0]
+}
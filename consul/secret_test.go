@@ -0,0 +1,67 @@
+package consul
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type secretTmp struct {
+	Url   string `json:"url" secret:"true"`
+	Conns int    `json:"conns"`
+	Inner struct {
+		Token string `json:"token" secret:"true"`
+	} `json:"inner"`
+}
+
+func TestSecret(t *testing.T) {
+	os.Setenv(EnvSecretKey, base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	url, err := Encrypt([]byte("root:pwd@tcp(127.0.0.1:3306)/db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole, err := Encrypt([]byte(`{"url":"u","conns":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := NewMapSource(map[string]string{
+		"test/field": `{"url":"` + url + `","conns":1}`,
+		"test/whole": whole,
+		"test/bad":   `{"url":"enc:xxx"}`,
+		"test/big":   `{"url":"` + url + `","conns":9007199254740993}`,
+	})
+	lo := WithSource(src).WithPrefix("test")
+
+	var s secretTmp
+	if err = lo.LoadJson("field", &s); err != nil || s.Url != "root:pwd@tcp(127.0.0.1:3306)/db" || s.Conns != 1 {
+		t.Errorf("get %+v err %v", s, err)
+	}
+	if err = lo.LoadYaml("whole", &s); err != nil || s.Url != "u" || s.Conns != 2 {
+		t.Errorf("get %+v err %v", s, err)
+	}
+	var big secretTmp
+	if err = lo.LoadJson("big", &big); err != nil || big.Conns != 9007199254740993 { //与加密字段同级的数字不丢失精度
+		t.Errorf("get %+v err %v", big, err)
+	}
+	if err = lo.LoadJson("bad", &s); err == nil {
+		t.Error("bad should fail")
+	}
+
+	s.Inner.Token = "t"
+	bs, _ := json.Marshal(mask(s))
+	if strings.Contains(string(bs), `"u"`) || strings.Contains(string(bs), `"t"`) || !strings.Contains(string(bs), `"conns":2`) {
+		t.Errorf("get %s", bs)
+	}
+	if got := maskBs([]byte(`{"URL":"u","conns":1}`), reflect.TypeOf(&s), json.Unmarshal); strings.Contains(got, `"u"`) {
+		t.Errorf("get %s", got)
+	}
+
+	var m map[string]secretTmp
+	lo.WatchPrefixJson("", &m, nil)
+	if m["field"].Url != "root:pwd@tcp(127.0.0.1:3306)/db" || m["whole"].Url != "u" {
+		t.Errorf("get %+v", m)
+	}
+}
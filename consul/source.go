@@ -0,0 +1,397 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//配置中的一个值
+type Pair struct {
+	Key   string
+	Value []byte
+	Index uint64 //修改版本，consul中是ModifyIndex，文件是修改时间，map是自增的版本号
+}
+
+//配置来源，GetJson、WatchJson等对任何来源都一样
+type Source interface {
+	//key不存在时返回nil, nil
+	Get(key string) (*Pair, error)
+	//value发生变化时调用handler，key被删除时pair为nil，不阻塞
+	Watch(key string, handler func(pair *Pair)) error
+	//返回以prefix开头的所有key
+	List(prefix string) ([]*Pair, error)
+	//以prefix开头的任何key发生变化（包括增删）时，调用handler传入所有key，不阻塞
+	WatchPrefix(prefix string, handler func(pairs []*Pair)) error
+}
+
+var source Source = kvSource{} //默认用consul KV
+
+//替换默认的配置来源，例如本地开发时用文件，单测时用map
+//NOTE: 非并发安全，只能用于初始化时候
+func SetSource(s Source) {
+	source = s
+}
+
+func WithSource(s Source) Consul {
+	return Consul{source: s}
+}
+
+func (m Consul) WithSource(s Source) Consul {
+	m.source = s
+	return m
+}
+
+func (m Consul) getSource() Source {
+	if m.source != nil {
+		return m.source
+	}
+	return source
+}
+
+//使用Init设置的KV和Address
+type kvSource struct{}
+
+func (kvSource) Get(key string) (*Pair, error) {
+	return KVSource{KV: KV, Address: Address}.Get(key)
+}
+
+func (kvSource) Watch(key string, handler func(pair *Pair)) error {
+	return KVSource{KV: KV, Address: Address}.Watch(key, handler)
+}
+
+func (kvSource) List(prefix string) ([]*Pair, error) {
+	return KVSource{KV: KV, Address: Address}.List(prefix)
+}
+
+func (kvSource) WatchPrefix(prefix string, handler func(pairs []*Pair)) error {
+	return KVSource{KV: KV, Address: Address}.WatchPrefix(prefix, handler)
+}
+
+//consul KV
+type KVSource struct {
+	KV      *api.KV
+	Address string //watch用
+}
+
+func (m KVSource) Get(key string) (*Pair, error) {
+	pair, _, err := m.KV.Get(key, nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	return &Pair{Key: key, Value: pair.Value, Index: pair.ModifyIndex}, nil
+}
+
+func (m KVSource) Watch(key string, handler func(pair *Pair)) error {
+	plan, err := watch.Parse(map[string]interface{}{
+		"type": "key",
+		"key":  key,
+	})
+	if err != nil {
+		return err
+	}
+	plan.Handler = func(idx uint64, raw interface{}) {
+		if kv, ok := raw.(*api.KVPair); ok && kv != nil {
+			handler(&Pair{Key: key, Value: kv.Value, Index: kv.ModifyIndex})
+		} else {
+			handler(nil)
+		}
+	}
+	go plan.Run(m.Address)
+	return nil
+}
+
+func (m KVSource) List(prefix string) ([]*Pair, error) {
+	kvs, _, err := m.KV.List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	return kvPairs(kvs), nil
+}
+
+func (m KVSource) WatchPrefix(prefix string, handler func(pairs []*Pair)) error {
+	plan, err := watch.Parse(map[string]interface{}{
+		"type":   "keyprefix",
+		"prefix": prefix,
+	})
+	if err != nil {
+		return err
+	}
+	plan.Handler = func(idx uint64, raw interface{}) {
+		kvs, _ := raw.(api.KVPairs)
+		handler(kvPairs(kvs))
+	}
+	go plan.Run(m.Address)
+	return nil
+}
+
+func kvPairs(kvs api.KVPairs) (pairs []*Pair) {
+	for _, kv := range kvs {
+		pairs = append(pairs, &Pair{Key: kv.Key, Value: kv.Value, Index: kv.ModifyIndex})
+	}
+	return
+}
+
+//本地文件，key就是Dir下的相对路径，通过轮询修改时间来watch
+type FileSource struct {
+	Dir      string
+	Interval time.Duration //watch轮询间隔，默认1s
+}
+
+func (m FileSource) Get(key string) (*Pair, error) {
+	path := filepath.Join(m.Dir, key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Pair{Key: key, Value: value, Index: uint64(info.ModTime().UnixNano())}, nil
+}
+
+func (m FileSource) Watch(key string, handler func(pair *Pair)) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	last, err := m.Get(key)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(interval) {
+			pair, err := m.Get(key)
+			if err != nil {
+				logrus.WithField("key", key).WithError(err).Error("file source watch failed")
+				continue
+			}
+			if (pair == nil) != (last == nil) || (pair != nil && pair.Index != last.Index) {
+				last = pair
+				handler(pair)
+			}
+		}
+	}()
+	return nil
+}
+
+func (m FileSource) List(prefix string) (pairs []*Pair, err error) {
+	root := filepath.Join(m.Dir, prefix)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(m.Dir, path)
+		if err != nil {
+			return err
+		}
+		pair, err := m.Get(filepath.ToSlash(rel))
+		if err != nil || pair == nil {
+			return err
+		}
+		pairs = append(pairs, pair)
+		return nil
+	})
+	return
+}
+
+func (m FileSource) WatchPrefix(prefix string, handler func(pairs []*Pair)) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	last, err := m.List(prefix)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(interval) {
+			pairs, err := m.List(prefix)
+			if err != nil {
+				logrus.WithField("prefix", prefix).WithError(err).Error("file source watch prefix failed")
+				continue
+			}
+			if pairsIndex(pairs) != pairsIndex(last) {
+				last = pairs
+				handler(pairs)
+			}
+		}
+	}()
+	return nil
+}
+
+//用于判断是否有变化
+func pairsIndex(pairs []*Pair) string {
+	var sb strings.Builder
+	for _, pair := range pairs {
+		sb.WriteString(pair.Key)
+		sb.WriteString(strconv.FormatUint(pair.Index, 10))
+	}
+	return sb.String()
+}
+
+//环境变量，key转成大写，非字母数字转成_，例如 test/service/example/mysql 对应 TEST_SERVICE_EXAMPLE_MYSQL
+//环境变量在运行中不会变，所以Watch不会回调
+type EnvSource struct{}
+
+func EnvName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key)
+	return strings.Trim(name, "_")
+}
+
+func (EnvSource) Get(key string) (*Pair, error) {
+	value, ok := os.LookupEnv(EnvName(key))
+	if !ok {
+		return nil, nil
+	}
+	return &Pair{Key: key, Value: []byte(value)}, nil
+}
+
+func (EnvSource) Watch(key string, handler func(pair *Pair)) error {
+	return nil
+}
+
+//环境变量名无法还原成key，所以返回的key是prefix加上去掉前缀后剩余部分的小写
+func (EnvSource) List(prefix string) (pairs []*Pair, err error) {
+	namePrefix := EnvName(prefix) + "_"
+	for _, env := range os.Environ() {
+		i := strings.Index(env, "=")
+		if i < 0 || !strings.HasPrefix(env[:i], namePrefix) {
+			continue
+		}
+		pairs = append(pairs, &Pair{
+			Key:   prefix + strings.ToLower(env[len(namePrefix):i]),
+			Value: []byte(env[i+1:]),
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return
+}
+
+func (EnvSource) WatchPrefix(prefix string, handler func(pairs []*Pair)) error {
+	return nil
+}
+
+//内存中的map，通过Set、Delete修改，常用于单测
+type MapSource struct {
+	mu             sync.Mutex
+	index          uint64
+	pairs          map[string]*Pair
+	handlers       map[string][]func(pair *Pair)
+	prefixHandlers map[string][]func(pairs []*Pair)
+}
+
+func NewMapSource(values map[string]string) *MapSource {
+	m := &MapSource{
+		pairs:          map[string]*Pair{},
+		handlers:       map[string][]func(pair *Pair){},
+		prefixHandlers: map[string][]func(pairs []*Pair){},
+	}
+	for k, v := range values {
+		m.index++
+		m.pairs[k] = &Pair{Key: k, Value: []byte(v), Index: m.index}
+	}
+	return m
+}
+
+func (m *MapSource) Get(key string) (*Pair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pairs[key], nil
+}
+
+func (m *MapSource) Watch(key string, handler func(pair *Pair)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[key] = append(m.handlers[key], handler)
+	return nil
+}
+
+func (m *MapSource) List(prefix string) ([]*Pair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.list(prefix), nil
+}
+
+func (m *MapSource) list(prefix string) (pairs []*Pair) {
+	for k, pair := range m.pairs {
+		if strings.HasPrefix(k, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return
+}
+
+func (m *MapSource) WatchPrefix(prefix string, handler func(pairs []*Pair)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefixHandlers[prefix] = append(m.prefixHandlers[prefix], handler)
+	return nil
+}
+
+//同步调用watch的handler
+func (m *MapSource) Set(key string, value []byte) {
+	m.mu.Lock()
+	m.index++
+	pair := &Pair{Key: key, Value: value, Index: m.index}
+	m.pairs[key] = pair
+	m.mu.Unlock()
+	m.notify(key, pair)
+}
+
+func (m *MapSource) Delete(key string) {
+	m.mu.Lock()
+	delete(m.pairs, key)
+	m.mu.Unlock()
+	m.notify(key, nil)
+}
+
+func (m *MapSource) notify(key string, pair *Pair) {
+	m.mu.Lock()
+	handlers := m.handlers[key]
+	type prefixCall struct {
+		handlers []func(pairs []*Pair)
+		pairs    []*Pair
+	}
+	var prefixCalls []prefixCall
+	for prefix, handlers := range m.prefixHandlers {
+		if strings.HasPrefix(key, prefix) {
+			prefixCalls = append(prefixCalls, prefixCall{handlers: handlers, pairs: m.list(prefix)})
+		}
+	}
+	m.mu.Unlock()
+	for _, handler := range handlers {
+		handler(pair)
+	}
+	for _, call := range prefixCalls {
+		for _, handler := range call.handlers {
+			handler(call.pairs)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package consul
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMapSource(t *testing.T) {
+	src := NewMapSource(map[string]string{
+		"test/d": `{"d":"1s"}`,
+	})
+	lo := WithSource(src).WithPrefix("test").ValiStruct()
+	var tmp Tmp
+	lo.GetJson("d", &tmp)
+	if tmp.D.Duration != time.Second {
+		t.Fatalf("get %s want 1s", tmp.D)
+	}
+
+	changed := 0
+	lo.WatchJson("d", &tmp, func() {
+		changed++
+	})
+	src.Set("test/d", []byte(`{"d":"2s"}`))
+	if tmp.D.Duration != 2*time.Second {
+		t.Errorf("get %s want 2s", tmp.D)
+	}
+	src.Set("test/d", []byte(`{}`)) //校验失败，不生效
+	if tmp.D.Duration != 2*time.Second || changed != 2 {
+		t.Errorf("get %s changed %d", tmp.D, changed)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "test"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "test", "i")
+	if err := ioutil.WriteFile(file, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lo := WithSource(FileSource{Dir: dir, Interval: 10 * time.Millisecond}).WithPrefix("test")
+	ch := make(chan int, 1)
+	lo.WatchJsonVarious("i", func(i int) {
+		ch <- i
+	})
+	if i := <-ch; i != 1 {
+		t.Fatalf("get %d want 1", i)
+	}
+	time.Sleep(20 * time.Millisecond) //让修改时间不同
+	if err := ioutil.WriteFile(file, []byte("2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case i := <-ch:
+		if i != 2 {
+			t.Errorf("get %d want 2", i)
+		}
+	case <-time.After(time.Second):
+		t.Error("watch timeout")
+	}
+	pairs, err := FileSource{Dir: dir}.List("test/")
+	if err != nil || len(pairs) != 1 || pairs[0].Key != "test/i" {
+		t.Errorf("get pairs %v err %v", pairs, err)
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	os.Setenv("TEST_SERVICE_EXAMPLE_B", "3")
+	defer os.Unsetenv("TEST_SERVICE_EXAMPLE_B")
+	var b int
+	WithSource(EnvSource{}).WithPrefix("test/service/example").ValiVar("min=2").GetJson("b", &b)
+	if b != 3 {
+		t.Errorf("get %d want 3", b)
+	}
+}
@@ -0,0 +1,185 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hash/fnv"
+	"sync/atomic"
+	"zlutils/consul"
+	"zlutils/session"
+)
+
+const (
+	On  = "on"
+	Off = "off"
+)
+
+//一个功能开关，对应consul中前缀下的一个key，例如 flags/new_ui
+//布尔开关的取值是On或Off，多变量开关的取值是任意字符串
+type Flag struct {
+	Enabled bool   `json:"enabled"`               //总开关，关闭时所有用户都取Off
+	Rules   []Rule `json:"rules" validate:"dive"` //按顺序匹配，第一个命中的规则决定取值
+	Default string `json:"default"`               //没有规则命中时的取值，为空时是Off
+	Salt    string `json:"salt"`                  //分桶的盐，为空时用开关名，修改后重新分桶
+}
+
+//规则的条件都是可选的，为空则不限制，都满足才算命中
+type Rule struct {
+	ProductIds     []int    `json:"product_ids"`
+	MinVersionCode int      `json:"min_version_code"`
+	MaxVersionCode int      `json:"max_version_code"` //为0时不限制
+	SimCountries   []string `json:"sim_countries"`
+	UserIdentities []string `json:"user_identities"` //白名单
+	Splits         []Split  `json:"splits" validate:"required,dive"`
+}
+
+//按UserIdentity的hash分到[0,100)的桶中，依次按Percent分给各个取值，
+//同一用户在同一开关下的桶是固定的，所以把Percent调大时已命中的用户保持不变，
+//Percent之和不足100时，剩下的用户继续匹配下一条规则
+type Split struct {
+	Variant string  `json:"variant" validate:"required"`
+	Percent float64 `json:"percent" validate:"min=0,max=100"`
+}
+
+var holder atomic.Value //*consul.Holder，快照是map[string]Flag
+
+//watch consul中prefix下的所有开关，例如Init("flags")，某个开关校验失败时保留它的旧值
+func Init(prefix string) {
+	InitWithConsul(consul.ValiStruct(), prefix)
+}
+
+//自定义consul的前缀、来源等
+func InitWithConsul(m consul.Consul, prefix string) {
+	holder.Store(m.ValiStruct().HoldPrefixJson(prefix, map[string]Flag{}))
+}
+
+//返回开关当前的配置，每次都读取最新的快照
+func Get(name string) (flag Flag, ok bool) {
+	h, _ := holder.Load().(*consul.Holder)
+	if h == nil {
+		return
+	}
+	flag, ok = h.Get().(map[string]Flag)[name]
+	return
+}
+
+//ctx中必须有用户，见WithUser、MidUser，开关不存在时返回Off
+func Variant(ctx context.Context, name string) string {
+	return VariantFor(UserFrom(ctx), name)
+}
+
+func Enabled(ctx context.Context, name string) bool {
+	return Variant(ctx, name) == On
+}
+
+func EnabledFor(user session.User, name string) bool {
+	return VariantFor(user, name) == On
+}
+
+func VariantFor(user session.User, name string) string {
+	flag, ok := Get(name)
+	if !ok {
+		return Off
+	}
+	return flag.Evaluate(name, user)
+}
+
+func (m Flag) Evaluate(name string, user session.User) string {
+	if !m.Enabled {
+		return Off
+	}
+	salt := m.Salt
+	if salt == "" {
+		salt = name
+	}
+	b := bucket(salt, user.UserIdentity)
+	for _, rule := range m.Rules {
+		if !rule.match(user) {
+			continue
+		}
+		var sum float64
+		for _, split := range rule.Splits {
+			if user.UserIdentity == "" { //没有用户标识时都会分到同一个桶，所以只命中全量的
+				if split.Percent >= 100 {
+					return split.Variant
+				}
+				continue
+			}
+			sum += split.Percent
+			if b < sum {
+				return split.Variant
+			}
+		}
+	}
+	if m.Default == "" {
+		return Off
+	}
+	return m.Default
+}
+
+func (m Rule) match(user session.User) bool {
+	if len(m.ProductIds) > 0 && !containsInt(m.ProductIds, user.ProductId) {
+		return false
+	}
+	if m.MinVersionCode > 0 && user.VersionCode < m.MinVersionCode {
+		return false
+	}
+	if m.MaxVersionCode > 0 && user.VersionCode > m.MaxVersionCode {
+		return false
+	}
+	if len(m.SimCountries) > 0 && !containsString(m.SimCountries, user.SimCountry) {
+		return false
+	}
+	if len(m.UserIdentities) > 0 && !containsString(m.UserIdentities, user.UserIdentity) {
+		return false
+	}
+	return true
+}
+
+//[0,100)，精确到0.01
+func bucket(salt, userIdentity string) float64 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%s", salt, userIdentity)
+	return float64(h.Sum32()%10000) / 100
+}
+
+func containsInt(is []int, i int) bool {
+	for _, v := range is {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type userKey struct{}
+
+func WithUser(ctx context.Context, user session.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+//ctx中没有用户时返回空用户，只会命中没有条件的规则；*gin.Context则取其Request的ctx
+func UserFrom(ctx context.Context) session.User {
+	if c, ok := ctx.(*gin.Context); ok {
+		ctx = c.Request.Context()
+	}
+	user, _ := ctx.Value(userKey{}).(session.User)
+	return user
+}
+
+//必须在session.MidUser之后，把用户放到请求的ctx中，之后业务层只需要ctx就能判断开关
+func MidUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(WithUser(c.Request.Context(), session.GetUser(c)))
+	}
+}
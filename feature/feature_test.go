@@ -0,0 +1,96 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+	"zlutils/consul"
+	"zlutils/session"
+)
+
+func TestFeature(t *testing.T) {
+	src := consul.NewMapSource(map[string]string{
+		"test/flags/new_ui": `{"enabled":true,"rules":[
+			{"user_identities":["tester"],"splits":[{"variant":"on","percent":100}]},
+			{"product_ids":[39],"min_version_code":100,"splits":[{"variant":"on","percent":50}]}
+		]}`,
+		"test/flags/color": `{"enabled":true,"default":"red","rules":[
+			{"sim_countries":["IN"],"splits":[{"variant":"blue","percent":50},{"variant":"green","percent":50}]}
+		]}`,
+		"test/flags/bad": `{"enabled":true,"rules":[{"splits":[{"percent":200}]}]}`,
+		"test/flags/all": `{"enabled":true,"rules":[{"splits":[{"variant":"on","percent":100}]}]}`,
+	})
+	InitWithConsul(consul.WithSource(src).WithPrefix("test"), "flags")
+
+	if !EnabledFor(session.User{UserIdentity: "tester"}, "new_ui") {
+		t.Error("tester should be on")
+	}
+	if EnabledFor(session.User{UserIdentity: "u", ProductId: 45, VersionCode: 200}, "new_ui") {
+		t.Error("product 45 should be off")
+	}
+	if _, ok := Get("bad"); ok {
+		t.Error("bad flag should be rejected")
+	}
+
+	on := 0
+	colors := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		user := session.User{UserIdentity: fmt.Sprint(i), ProductId: 39, VersionCode: 100, SimCountry: "IN"}
+		if EnabledFor(user, "new_ui") {
+			on++
+		}
+		if EnabledFor(user, "new_ui") != EnabledFor(user, "new_ui") {
+			t.Fatal("bucket not stable")
+		}
+		colors[VariantFor(user, "color")]++
+	}
+	if on < 400 || on > 600 {
+		t.Errorf("get on %d", on)
+	}
+	if colors["red"] != 0 || colors["blue"] < 400 || colors["green"] < 400 {
+		t.Errorf("get colors %v", colors)
+	}
+	if v := VariantFor(session.User{SimCountry: "US"}, "color"); v != "red" {
+		t.Errorf("get %s", v)
+	}
+
+	//没有用户标识时不放量，除非是全量
+	anonymous := session.User{ProductId: 39, VersionCode: 100, SimCountry: "IN"}
+	if EnabledFor(anonymous, "new_ui") || VariantFor(anonymous, "color") != "red" || !EnabledFor(anonymous, "all") {
+		t.Errorf("get %v %s %v", EnabledFor(anonymous, "new_ui"), VariantFor(anonymous, "color"), EnabledFor(anonymous, "all"))
+	}
+
+	src.Set("test/flags/new_ui", []byte(`{"enabled":false}`))
+	if EnabledFor(session.User{UserIdentity: "tester"}, "new_ui") {
+		t.Error("disabled flag should be off")
+	}
+	if v := VariantFor(session.User{}, "not_exist"); v != Off {
+		t.Errorf("get %s", v)
+	}
+}
+
+func TestMidUser(t *testing.T) {
+	src := consul.NewMapSource(map[string]string{
+		"test/mid/a": `{"enabled":true,"rules":[{"product_ids":[39],"splits":[{"variant":"on","percent":100}]}]}`,
+	})
+	InitWithConsul(consul.WithSource(src).WithPrefix("test"), "mid")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var fromGin, fromCtx bool
+	router.GET("/", session.WithoutValidate().MidUser(), MidUser(), func(c *gin.Context) {
+		fromGin = Enabled(c, "a")
+		fromCtx = Enabled(c.Request.Context(), "a")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Product-Id", "39")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if !fromGin || !fromCtx {
+		t.Errorf("get %v %v", fromGin, fromCtx)
+	}
+	if Enabled(context.Background(), "a") {
+		t.Error("no user should be off")
+	}
+}
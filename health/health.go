@@ -0,0 +1,209 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lun-zhang/gorm"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"zlutils/redis"
+	"zlutils/request"
+)
+
+const (
+	LivenessPath  = "/health/live"
+	ReadinessPath = "/health/ready" //consul.RegisterConfig.HealthPath默认用这个
+
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+//返回err表示依赖不可用，必须在ctx结束后尽快返回
+type Probe func(ctx context.Context) error
+
+type ProbeConfig struct {
+	Timeout  time.Duration //默认2s
+	CacheTTL time.Duration //结果缓存多久，避免consul、k8s等频繁检查压垮依赖，默认3s，负数不缓存
+	Optional bool          //失败时只在报告中体现，不影响整体状态
+}
+
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+	Optional  bool      `json:"optional,omitempty"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type probe struct {
+	name   string
+	probe  Probe
+	config ProbeConfig
+	mu     sync.Mutex //同时只执行一次，其他的等待其结果
+	result Result
+}
+
+func (m *probe) check(ctx context.Context) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.result.CheckedAt.IsZero() && time.Since(m.result.CheckedAt) < m.config.CacheTTL {
+		return m.result
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+	begin := time.Now()
+	errCh := make(chan error, 1)
+	go func() { //probe不响应ctx时也不阻塞
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errCh <- m.probe(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := Result{
+		Status:    StatusUp,
+		Duration:  time.Since(begin).String(),
+		CheckedAt: begin,
+		Optional:  m.config.Optional,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	m.result = result
+	return result
+}
+
+//一组探针
+type Checker struct {
+	mu     sync.Mutex
+	probes []*probe
+}
+
+func (m *Checker) Add(name string, p Probe, config ProbeConfig) {
+	if config.Timeout == 0 {
+		config.Timeout = 2 * time.Second
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = 3 * time.Second
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.probes = append(m.probes, &probe{name: name, probe: p, config: config})
+}
+
+//并发执行所有探针
+func (m *Checker) Check(ctx context.Context) Report {
+	m.mu.Lock()
+	probes := append([]*probe{}, m.probes...)
+	m.mu.Unlock()
+	report := Report{Status: StatusUp, Checks: map[string]Result{}}
+	results := make([]Result, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p *probe) {
+			defer wg.Done()
+			results[i] = p.check(ctx)
+		}(i, p)
+	}
+	wg.Wait()
+	for i, p := range probes {
+		report.Checks[p.name] = results[i]
+		if results[i].Status != StatusUp && !p.config.Optional {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+//返回json格式的报告，不可用时状态码为503
+func (m *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := m.Check(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+func (m *Checker) Names() (names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.probes {
+		names = append(names, p.name)
+	}
+	sort.Strings(names)
+	return
+}
+
+var (
+	//进程是否还活着，失败时应当重启，一般不需要添加依赖的探针，否则依赖故障会导致所有实例重启
+	Liveness = &Checker{}
+	//是否可以接收流量，失败时从consul中摘除
+	Readiness = &Checker{}
+)
+
+func AddLiveness(name string, p Probe, config ProbeConfig) {
+	Liveness.Add(name, p, config)
+}
+
+func AddReadiness(name string, p Probe, config ProbeConfig) {
+	Readiness.Add(name, p, config)
+}
+
+func LivenessHandler() http.Handler {
+	return Liveness.Handler()
+}
+
+func ReadinessHandler() http.Handler {
+	return Readiness.Handler()
+}
+
+//注册LivenessPath和ReadinessPath
+func Route(router gin.IRouter) {
+	router.GET(LivenessPath, gin.WrapH(LivenessHandler()))
+	router.GET(ReadinessPath, gin.WrapH(ReadinessHandler()))
+}
+
+func MySQL(db *gorm.DB) Probe {
+	return func(ctx context.Context) error {
+		return db.DB().PingContext(ctx)
+	}
+}
+
+func Redis(client *redis.Client) Probe {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
+
+//请求下游，状态码为200则可用，body被丢弃
+func Request(config request.Config) Probe {
+	return func(ctx context.Context) error {
+		return request.Request{Config: config}.DoStream(ctx, func(header http.Header, body io.Reader) error {
+			_, err := io.Copy(ioutil.Discard, body)
+			return err
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zlutils/consul"
+	"zlutils/request"
+)
+
+func TestReadinessPath(t *testing.T) {
+	if ReadinessPath != consul.DefaultHealthPath { //consul注册时默认检查它
+		t.Errorf("get %s want %s", ReadinessPath, consul.DefaultHealthPath)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	var calls int32
+	c := &Checker{}
+	c.Add("ok", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, ProbeConfig{CacheTTL: time.Minute})
+	c.Add("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second) //不响应ctx
+		return nil
+	}, ProbeConfig{Timeout: 10 * time.Millisecond, Optional: true})
+
+	w := httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var report Report
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if w.Code != http.StatusOK || report.Status != StatusUp || report.Checks["slow"].Status != StatusDown {
+		t.Errorf("get %d %s", w.Code, w.Body.String())
+	}
+	c.Check(context.Background())
+	if calls != 1 {
+		t.Errorf("get calls %d want cached", calls)
+	}
+
+	c.Add("down", func(ctx context.Context) error {
+		return fmt.Errorf("connection refused")
+	}, ProbeConfig{})
+	w = httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if w.Code != http.StatusServiceUnavailable || report.Checks["down"].Error != "connection refused" {
+		t.Errorf("get %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestProbe(t *testing.T) {
+	mock := request.NewMockServer()
+	defer mock.Close()
+	mock.On("GET", "/ok").Reply(http.StatusOK, "ok")
+	mock.On("GET", "/bad").Reply(http.StatusInternalServerError, "bad")
+	ctx := context.Background()
+	if err := Request(request.Config{Method: "GET", Url: mock.URL + "/ok"})(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := Request(request.Config{Method: "GET", Url: mock.URL + "/bad"})(ctx); err == nil {
+		t.Error("want err")
+	}
+}
@@ -0,0 +1,167 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/fvbock/endless"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"zlutils/consul"
+	"zlutils/prometheus"
+	zt "zlutils/time"
+)
+
+type Config struct {
+	DrainPeriod     zt.Duration `json:"drain_period"`     //进入维护状态后等多久再注销，让调用方的负载均衡感知到，默认10s
+	ShutdownTimeout zt.Duration `json:"shutdown_timeout"` //注销、shutdown hook各自的超时，默认15s
+	HammerTime      zt.Duration `json:"hammer_time"`      //http服务器停止接收新连接后，等待已有请求多久后强制关闭，默认endless.DefaultHammerTime
+}
+
+//退出时依次：consul进入维护状态 -> 等待DrainPeriod -> 从consul和prometheus注销 -> 停止http服务器 -> 执行shutdown hook
+type Manager struct {
+	config   Config
+	mu       sync.Mutex
+	hooks    []hook
+	draining int32
+	drain    sync.Once
+	shutdown sync.Once
+	err      error
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+//以下步骤可替换，方便测试
+var (
+	enableMaintenance    = consul.EnableMaintenance
+	deregisterConsul     = consul.DeregisterCtx
+	unregisterPrometheus = prometheus.UnregisterCtx
+	notifySignal         = signal.Notify
+	stopSignal           = signal.Stop
+)
+
+func New(config Config) *Manager {
+	if config.DrainPeriod.Duration == 0 {
+		config.DrainPeriod.Duration = 10 * time.Second
+	}
+	if config.ShutdownTimeout.Duration == 0 {
+		config.ShutdownTimeout.Duration = 15 * time.Second
+	}
+	return &Manager{config: config}
+}
+
+//退出时执行，按注册的逆序执行（与defer相同），例如关闭数据库连接、flush日志，每个hook的ctx在ShutdownTimeout后结束，超时不影响后面的hook
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+//是否正在退出，可以加到readiness探针中
+func (m *Manager) Draining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
+}
+
+//进入维护状态，等待DrainPeriod，然后从consul和prometheus注销，只执行一次
+func (m *Manager) Drain() {
+	m.drain.Do(func() {
+		atomic.StoreInt32(&m.draining, 1)
+		entry := logrus.WithField("drain_period", m.config.DrainPeriod.String())
+		if err := enableMaintenance("shutting down"); err != nil {
+			entry.WithError(err).Error("consul enable maintenance failed")
+		}
+		entry.Info("draining")
+		time.Sleep(m.config.DrainPeriod.Duration)
+		//consul不可用、prometheus没有注册时会一直阻塞，所以共用ShutdownTimeout
+		ctx, cancel := context.WithTimeout(context.Background(), m.config.ShutdownTimeout.Duration)
+		defer cancel()
+		if err := deregisterConsul(ctx); err != nil {
+			entry.WithError(err).Error("consul deregister failed")
+		}
+		if err := unregisterPrometheus(ctx); err != nil {
+			entry.WithError(err).Error("prometheus unregister failed")
+		}
+		entry.Info("drained")
+	})
+}
+
+//逆序执行shutdown hook，只执行一次，返回所有失败的hook
+func (m *Manager) Shutdown() error {
+	m.shutdown.Do(func() {
+		m.mu.Lock()
+		hooks := append([]hook{}, m.hooks...)
+		m.mu.Unlock()
+		var errs []string
+		for i := len(hooks) - 1; i >= 0; i-- {
+			h := hooks[i]
+			entry := logrus.WithField("hook", h.name)
+			ctx, cancel := context.WithTimeout(context.Background(), m.config.ShutdownTimeout.Duration)
+			errCh := make(chan error, 1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						errCh <- fmt.Errorf("panic: %v", r)
+					}
+				}()
+				errCh <- h.fn(ctx)
+			}()
+			var err error
+			select {
+			case err = <-errCh:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			cancel()
+			if err != nil {
+				entry.WithError(err).Error("shutdown hook failed")
+				errs = append(errs, fmt.Sprintf("%s: %s", h.name, err))
+				continue
+			}
+			entry.Info("shutdown hook ok")
+		}
+		if len(errs) > 0 {
+			m.err = fmt.Errorf("shutdown hooks failed, %s", strings.Join(errs, "; "))
+		}
+	})
+	return m.err
+}
+
+//用endless启动http服务器，收到SIGTERM、SIGINT时先Drain，然后停止接收新连接，等已有请求结束后执行Shutdown，
+//SIGHUP时endless会fork新进程平滑重启，不会注销
+func (m *Manager) ListenAndServe(addr string, handler http.Handler) error {
+	if m.config.HammerTime.Duration != 0 {
+		endless.DefaultHammerTime = m.config.HammerTime.Duration
+	}
+	srv := endless.NewServer(addr, handler)
+	for _, sig := range []os.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		srv.SignalHooks[endless.PRE_SIGNAL][sig] = append(srv.SignalHooks[endless.PRE_SIGNAL][sig], m.Drain)
+	}
+	err := srv.ListenAndServe()
+	if m.Draining() {
+		err = nil //关闭listener导致的err
+	}
+	if e := m.Shutdown(); err == nil {
+		err = e
+	}
+	return err
+}
+
+//不用endless时，阻塞到收到SIGTERM、SIGINT，然后Drain，之后自行停止http服务器并调用Shutdown
+func (m *Manager) WaitSignal() os.Signal {
+	c := make(chan os.Signal, 1)
+	notifySignal(c, syscall.SIGTERM, syscall.SIGINT)
+	defer stopSignal(c)
+	sig := <-c
+	logrus.WithField("signal", sig.String()).Info("received signal")
+	m.Drain()
+	return sig
+}
@@ -0,0 +1,107 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	zt "zlutils/time"
+)
+
+func TestManager(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		steps []string
+	)
+	step := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, s)
+	}
+	enableMaintenance = func(reason string) error {
+		step("maintenance")
+		return nil
+	}
+	deregisterConsul = func(ctx context.Context) error {
+		step("consul")
+		return nil
+	}
+	unregisterPrometheus = func(ctx context.Context) error {
+		<-ctx.Done() //没有注册时一直阻塞到ctx结束
+		step("prometheus")
+		return ctx.Err()
+	}
+	m := New(Config{
+		DrainPeriod:     zt.Duration{Duration: 10 * time.Millisecond},
+		ShutdownTimeout: zt.Duration{Duration: 50 * time.Millisecond},
+	})
+	m.OnShutdown("db", func(ctx context.Context) error {
+		step("db")
+		return nil
+	})
+	m.OnShutdown("slow", func(ctx context.Context) error {
+		step("slow")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	m.OnShutdown("log", func(ctx context.Context) error {
+		step("log")
+		return fmt.Errorf("flush failed")
+	})
+
+	notifySignal = func(c chan<- os.Signal, sig ...os.Signal) { //不给自己发信号，避免影响同时运行的其他测试
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			c <- syscall.SIGTERM
+		}()
+	}
+	stopSignal = func(c chan<- os.Signal) {}
+	if sig := m.WaitSignal(); sig != syscall.SIGTERM || !m.Draining() {
+		t.Fatalf("get %v", sig)
+	}
+	m.Drain() //只执行一次
+	err := m.Shutdown()
+	if err == nil || !strings.Contains(err.Error(), "log: flush failed") || !strings.Contains(err.Error(), "slow: context deadline exceeded") {
+		t.Errorf("get err %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(steps, ","); got != "maintenance,consul,prometheus,log,slow,db" {
+		t.Errorf("get steps %s", got)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	enableMaintenance = func(reason string) error {
+		return nil
+	}
+	var deadlines int32
+	deregisterConsul = func(ctx context.Context) error {
+		<-ctx.Done() //consul不可用
+		atomic.AddInt32(&deadlines, 1)
+		return ctx.Err()
+	}
+	unregisterPrometheus = func(ctx context.Context) error {
+		if ctx.Err() != nil { //与consul共用超时
+			atomic.AddInt32(&deadlines, 1)
+		}
+		return ctx.Err()
+	}
+	m := New(Config{
+		DrainPeriod:     zt.Duration{Duration: time.Millisecond},
+		ShutdownTimeout: zt.Duration{Duration: 50 * time.Millisecond},
+	})
+	begin := time.Now()
+	m.Drain()
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("blocked %s", d)
+	}
+	if deadlines != 2 {
+		t.Errorf("get deadlines %d", deadlines)
+	}
+}
@@ -105,7 +105,7 @@ func NewMasterAndSlave(config ConfigMasterAndSlave) *gorm.DB {
 }
 
 type Config struct {
-	Url          string `json:"url"`
+	Url          string `json:"url" secret:"true"` //consul打日志时隐藏
 	MaxOpenConns int    `json:"max_open_conns"`
 	MaxIdleConns int    `json:"max_idle_conns"`
 }
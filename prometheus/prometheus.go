@@ -2,70 +2,32 @@ package prometheus
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
-	"os"
-	"time"
+	"zlutils/address"
 	"zlutils/guard"
 	"zlutils/request"
 )
 
-//目前只返回ecs的，允许用户自定义（也方便测试）
-var GetAddress = func() (address string, err error) {
-	for i := 1; i <= 32; i <<= 1 {
-		time.Sleep(time.Duration(i) * time.Second)
-		address, err = getAddressOnEcs()
-		if err == nil {
-			return
-		}
-	}
-	err = fmt.Errorf("cant get address")
-	logrus.WithError(err).Error()
-	return "", err
-}
-
-func getAddressOnEcs() (address string, err error) {
-	ecsMeta := os.Getenv("ECS_CONTAINER_METADATA_FILE")
-	if ecsMeta == "" {
-		err = fmt.Errorf("ecsMeta is empty")
-		logrus.WithError(err).Error()
-		return
-	}
-	entry := logrus.WithField("ecsMeta", ecsMeta)
-	ecsData, err := ioutil.ReadFile(ecsMeta)
+//与consul.GetAddress相同，依次尝试address.Providers，允许用户自定义（也方便测试）
+var GetAddress = func() (addr string, err error) {
+	ip, port, err := address.GetWithTimeout()
 	if err != nil {
-		entry.WithError(err).Warn("read ecs meta file failed")
-		return
-	}
-	entry = entry.WithField("ecsData", string(ecsData))
-
-	var metaData struct {
-		PortMappings []struct {
-			HostPort int `json:"HostPort"`
-		} `json:"PortMappings"`
-		HostPrivateIPv4Address string `json:"HostPrivateIPv4Address"`
-		MetadataFileStatus     string `json:"MetadataFileStatus"`
-	}
-	if err = json.Unmarshal(ecsData, &metaData); err != nil {
-		entry.WithError(err).Error("decode meta json failed")
-		return
-	}
-	entry = entry.WithField("metaData", metaData)
-	if metaData.MetadataFileStatus != "READY" || len(metaData.PortMappings) == 0 {
-		err = fmt.Errorf("hasn't ready")
-		entry.WithError(err).Error()
-		return
+		return "", err
 	}
-	entry.Debug()
-	return fmt.Sprintf("%s:%d", metaData.HostPrivateIPv4Address, metaData.PortMappings[0].HostPort), nil
+	return fmt.Sprintf("%s:%d", ip, port), nil
 }
 
 var reqCh = make(chan request.Request, 1)
 
+//没有注册时一直阻塞
 func Unregister() (err error) {
-	ctx, cancel := context.WithCancel(context.Background())
+	return UnregisterCtx(context.Background())
+}
+
+//与Unregister相同，ctx结束时返回ctx.Err()
+func UnregisterCtx(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	defer guard.BeforeCtx(&ctx)(&err)
 	var resp request.RespRet
@@ -78,9 +40,9 @@ func Unregister() (err error) {
 			}
 			logrus.WithField("req", req).Info("unregister ok")
 		}
-		//case <-time.After(timeout):
-		//	err = fmt.Errorf("no req after %s timeout", timeout)
-		//	logrus.WithError(err).Error()
+	case <-ctx.Done():
+		err = ctx.Err()
+		logrus.WithError(err).Error("unregister canceled")
 	}
 	return
 }
@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"math"
+)
+
+type BloomConfig struct {
+	Key           string  `json:"key" validate:"required"`             //保存bitmap的redis key
+	Capacity      uint64  `json:"capacity" validate:"min=1"`           //预计元素个数
+	FalsePositive float64 `json:"false_positive" validate:"gt=0,lt=1"` //误判率，例如0.01
+}
+
+//用redis bitmap实现的布隆过滤器，多个进程共享，只能添加不能删除，
+//Exists返回false时一定不存在，返回true时可能不存在
+type Bloom struct {
+	client *Client
+	key    string
+	bits   uint64 //bitmap长度
+	hashes uint64 //每个元素的hash个数
+}
+
+//NOTE: config不合法时panic，bitmap最长2^32位（512MB）
+func (client *Client) NewBloom(config BloomConfig) *Bloom {
+	entry := logrus.WithField("config", config)
+	if err := vali.Struct(config); err != nil {
+		entry.WithError(err).Panic("redis bloom config invalid")
+	}
+	n := float64(config.Capacity)
+	bits := math.Ceil(-n * math.Log(config.FalsePositive) / (math.Ln2 * math.Ln2))
+	if bits > math.MaxUint32 {
+		entry.Panic("redis bloom too large")
+	}
+	hashes := math.Max(1, math.Round(bits/n*math.Ln2))
+	return &Bloom{
+		client: client,
+		key:    config.Key,
+		bits:   uint64(bits),
+		hashes: uint64(hashes),
+	}
+}
+
+//双重hash，第i个位置为h1+i*h2
+func (m *Bloom) offsets(item string) []int64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	h1 := h.Sum64()
+	h = fnv.New64()
+	h.Write([]byte(item))
+	h2 := h.Sum64() | 1
+	offsets := make([]int64, m.hashes)
+	for i := range offsets {
+		offsets[i] = int64((h1 + uint64(i)*h2) % m.bits)
+	}
+	return offsets
+}
+
+func (m *Bloom) Add(ctx context.Context, items ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	pipe := m.client.Pipeline()
+	defer pipe.Close()
+	for _, item := range items {
+		for _, offset := range m.offsets(item) {
+			pipe.SetBit(ctx, m.key, offset, 1)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+//返回每个item是否可能存在
+func (m *Bloom) Exists(ctx context.Context, items ...string) ([]bool, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	pipe := m.client.Pipeline()
+	defer pipe.Close()
+	cmds := make([][]*redis.IntCmd, len(items))
+	for i, item := range items {
+		for _, offset := range m.offsets(item) {
+			cmds[i] = append(cmds[i], pipe.GetBit(ctx, m.key, offset))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	exists := make([]bool, len(items))
+	for i := range items {
+		exists[i] = true
+		for _, cmd := range cmds[i] {
+			if cmd.Val() == 0 {
+				exists[i] = false
+				break
+			}
+		}
+	}
+	return exists, nil
+}
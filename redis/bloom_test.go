@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"testing"
+)
+
+func TestBloom(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	bloom := client.NewBloom(BloomConfig{Key: "bloom", Capacity: 1000, FalsePositive: 0.01})
+	if bloom.bits != 9586 || bloom.hashes != 7 {
+		t.Errorf("bits %d hashes %d", bloom.bits, bloom.hashes)
+	}
+	var items []string
+	for i := 0; i < 1000; i++ {
+		items = append(items, fmt.Sprint("user:", i))
+	}
+	if err := bloom.Add(ctx, items...); err != nil {
+		t.Fatal(err)
+	}
+	exists, err := bloom.Exists(ctx, items...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range exists {
+		if !ok {
+			t.Fatalf("%s not exists", items[i])
+		}
+	}
+
+	var others []string
+	for i := 1000; i < 2000; i++ {
+		others = append(others, fmt.Sprint("user:", i))
+	}
+	exists, err = bloom.Exists(ctx, others...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	falsePositive := 0
+	for _, ok := range exists {
+		if ok {
+			falsePositive++
+		}
+	}
+	if falsePositive > 30 {
+		t.Errorf("false positive %d/1000", falsePositive)
+	}
+}
@@ -0,0 +1,532 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zlutils/guard"
+	zt "zlutils/time"
+)
+
+type CacheConfig struct {
+	TTL zt.Duration `json:"ttl"` //过期时间，0则不过期
+	//在TTL上随机增加[0, Jitter*TTL)，避免同一批写入的key同时过期
+	Jitter float64 `json:"jitter" validate:"min=0,max=1"`
+	//过期后还能使用多久，期间返回旧值并在后台回源（stale-while-revalidate），0则不启用，
+	//redis中的实际ttl为TTL+Jitter+StaleTTL
+	StaleTTL zt.Duration `json:"stale_ttl"`
+	//概率提前刷新（XFetch），越接近过期、回源越慢越可能提前在后台回源，越大越早刷新，一般为1，0则不提前刷新
+	Beta float64 `json:"beta" validate:"min=0"`
+	//后台回源的超时，默认10s
+	RefreshTimeout zt.Duration `json:"refresh_timeout"`
+	//回源也没有的key保存一个占位值，期间不再回源，避免缓存穿透，一般比TTL短，0则不保存
+	NegativeTTL zt.Duration `json:"negative_ttl"`
+	//不为nil时，布隆过滤器中不存在的key当作不存在，不查redis也不回源，新增数据时需要调用Bloom.Add(redisKey)
+	Bloom *Bloom `json:"-"`
+}
+
+//不存在的key在redis中保存的值，不是合法的json，GetJson、MGetJsonMap当作key不存在
+const negativeValue = "\x00nil"
+
+//带回源的缓存，同一进程中同一key同时只有一个回源，其他的等待其结果（singleflight）
+type Cache struct {
+	client *Client
+	config CacheConfig
+	delta  int64 //回源耗时的滑动平均，纳秒
+
+	mu      sync.Mutex //保护closed，保证Close后不再wg.Add
+	closed  bool
+	refresh sync.WaitGroup //后台回源
+}
+
+//NOTE: config不合法时panic
+func (client *Client) NewCache(config CacheConfig) *Cache {
+	if err := vali.Struct(config); err != nil {
+		logrus.WithField("config", config).WithError(err).Panic("redis cache config invalid")
+	}
+	if config.RefreshTimeout.Duration == 0 {
+		config.RefreshTimeout.Duration = 10 * time.Second
+	}
+	return &Cache{client: client, config: config}
+}
+
+//不再后台回源，并等待正在进行的后台回源结束，退出前调用，之后的读取和同步回源不受影响
+func (m *Cache) Close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.refresh.Wait()
+}
+
+//后台回源，Close后不再执行
+func (m *Cache) goRefresh(keys []string, idx []int, fill fillBytes) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.refresh.Add(1)
+	go func() {
+		defer m.refresh.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), m.config.RefreshTimeout.Duration)
+		defer cancel()
+		if _, err := m.fill(ctx, keys, idx, fill, false); err != nil {
+			logrus.WithField("keys", keys).WithError(err).Warn("redis cache refresh failed")
+		}
+	}()
+}
+
+//先查缓存，没有则调用fill回源并写入缓存，fill返回的值用json序列化，结果写入outPtr，
+//fill返回Nil表示不存在，此时也返回Nil
+func (m *Cache) GetOrFill(ctx context.Context, key string, outPtr interface{}, fill func(ctx context.Context) (interface{}, error)) (err error) {
+	defer guard.BeforeCtx(&ctx)(&err)
+	entry := logrus.WithContext(ctx).WithField("key", key)
+	bss, err := m.load(ctx, []string{key}, func(ctx context.Context, idx []int) (map[int][]byte, error) {
+		value, err := fill(ctx)
+		if err == Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		bs, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return map[int][]byte{0: bs}, nil
+	})
+	if err != nil {
+		entry.WithError(err).Error()
+		return
+	}
+	if bss[0] == nil {
+		return Nil
+	}
+	if err = json.Unmarshal(bss[0], outPtr); err != nil {
+		entry.WithError(err).Error()
+		return
+	}
+	return
+}
+
+//参数与BizMGetJsonMapWithFill相同，bizKeys中重复的key只查一次
+func (m *Cache) MGetOrFill(ctx context.Context, bizKeys, keyFunc, fillFunc, outPtr interface{}) (err error) {
+	defer guard.BeforeCtx(&ctx)(&err)
+	entry := logrus.WithContext(ctx).
+		WithFields(logrus.Fields{
+			"bizKeys": bizKeys,
+			"config":  m.config,
+		})
+
+	//检查bizKeys类型
+	bizKeysValue := reflect.ValueOf(bizKeys)
+	if kind := bizKeysValue.Kind(); kind != reflect.Slice {
+		err = fmt.Errorf("bizKeys kind %s must be slice", kind)
+		entry.WithError(err).Error()
+		return
+	}
+	bizKeysType := bizKeysValue.Type()
+	bizKeyType := bizKeysType.Elem()
+
+	//检查keyFunc
+	keyFuncValue := reflect.ValueOf(keyFunc)
+	if err = checkKeyFunc(reflect.TypeOf(keyFunc), bizKeyType); err != nil {
+		entry.WithError(err).Error()
+		return
+	}
+
+	//检查outPtr
+	outPtrType := reflect.TypeOf(outPtr)
+	if err = checkOut(outPtrType, bizKeyType); err != nil {
+		entry.WithError(err).Error()
+		return
+	}
+	outType := outPtrType.Elem()
+	bizValueType := outType.Elem()
+
+	//检查fillFunc
+	if fillFunc != nil {
+		if err = checkFillFunc(reflect.TypeOf(fillFunc), bizKeysType, outType); err != nil {
+			entry.WithError(err).Error()
+			return
+		}
+	}
+	cachedMapValue := reflect.ValueOf(outPtr).Elem()
+	cachedMapValue.Set(reflect.MakeMap(outType))
+	if bizKeysValue.Len() == 0 {
+		return //key为空数组就不执行
+	}
+
+	//去重
+	var redisKeys []string
+	var bizKeyValues []reflect.Value
+	seen := map[string]bool{}
+	for i := 0; i < bizKeysValue.Len(); i++ {
+		bizKey := bizKeysValue.Index(i)
+		redisKey := keyFuncValue.Call([]reflect.Value{bizKey})[0].String()
+		if seen[redisKey] {
+			continue
+		}
+		seen[redisKey] = true
+		redisKeys = append(redisKeys, redisKey)
+		bizKeyValues = append(bizKeyValues, bizKey)
+	}
+	entry = entry.WithField("redisKeys", redisKeys)
+
+	var fill fillBytes
+	if fillFunc != nil {
+		fillFuncValue := reflect.ValueOf(fillFunc)
+		fill = func(ctx context.Context, idx []int) (map[int][]byte, error) {
+			noCachedBizKeysValue := reflect.MakeSlice(bizKeysType, 0, len(idx))
+			byKey := map[string]int{}
+			for _, i := range idx {
+				noCachedBizKeysValue = reflect.Append(noCachedBizKeysValue, bizKeyValues[i])
+				byKey[redisKeys[i]] = i
+			}
+			out := fillFuncValue.Call([]reflect.Value{reflect.ValueOf(ctx), noCachedBizKeysValue})
+			if !out[1].IsNil() {
+				return nil, out[1].Interface().(error)
+			}
+			filled := map[int][]byte{}
+			for iter := out[0].MapRange(); iter.Next(); {
+				redisKey := keyFuncValue.Call([]reflect.Value{iter.Key()})[0].String()
+				i, ok := byKey[redisKey]
+				if !ok {
+					continue //不是本次要回源的key
+				}
+				bs, err := json.Marshal(iter.Value().Interface())
+				if err != nil {
+					return nil, err
+				}
+				filled[i] = bs
+			}
+			return filled, nil
+		}
+	}
+
+	bss, err := m.load(ctx, redisKeys, fill)
+	if err != nil {
+		entry.WithError(err).Error()
+		return
+	}
+	for i, bs := range bss {
+		if bs == nil {
+			continue
+		}
+		newValuePtr := reflect.New(bizValueType)
+		if err = json.Unmarshal(bs, newValuePtr.Interface()); err != nil {
+			entry.WithError(err).Error()
+			return
+		}
+		cachedMapValue.SetMapIndex(bizKeyValues[i], newValuePtr.Elem())
+	}
+	return nil
+}
+
+//回源keys[i] (i in idx)，返回找到的key的json，没找到的不在map中
+type fillBytes func(ctx context.Context, idx []int) (map[int][]byte, error)
+
+//返回每个key的json，不存在且回源也没有的为nil，fill为nil时不回源
+func (m *Cache) load(ctx context.Context, keys []string, fill fillBytes) ([][]byte, error) {
+	if m.config.Bloom == nil {
+		return m.loadKeys(ctx, keys, fill)
+	}
+	exists, err := m.config.Bloom.Exists(ctx, keys...)
+	if err != nil { //布隆过滤器不可用时不过滤
+		logrus.WithContext(ctx).WithField("keys", keys).WithError(err).Warn("redis bloom check failed")
+		return m.loadKeys(ctx, keys, fill)
+	}
+	var existKeys []string
+	var existIdx []int //existKeys在keys中的下标
+	for i, ok := range exists {
+		if ok {
+			existKeys = append(existKeys, keys[i])
+			existIdx = append(existIdx, i)
+		}
+	}
+	bss := make([][]byte, len(keys))
+	if len(existKeys) == 0 {
+		return bss, nil
+	}
+	var existFill fillBytes
+	if fill != nil {
+		existFill = func(ctx context.Context, idx []int) (map[int][]byte, error) {
+			keysIdx := make([]int, len(idx))
+			for j, i := range idx {
+				keysIdx[j] = existIdx[i]
+			}
+			filled, err := fill(ctx, keysIdx)
+			if err != nil {
+				return nil, err
+			}
+			result := map[int][]byte{}
+			for j, i := range idx {
+				if bs, ok := filled[keysIdx[j]]; ok {
+					result[i] = bs
+				}
+			}
+			return result, nil
+		}
+	}
+	existBss, err := m.loadKeys(ctx, existKeys, existFill)
+	if err != nil {
+		return nil, err
+	}
+	for j, bs := range existBss {
+		bss[existIdx[j]] = bs
+	}
+	return bss, nil
+}
+
+func (m *Cache) loadKeys(ctx context.Context, keys []string, fill fillBytes) ([][]byte, error) {
+	bss, refresh, err := m.read(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if fill == nil {
+		return removeNegative(bss), nil
+	}
+	if len(refresh) > 0 {
+		m.goRefresh(keys, refresh, fill)
+	}
+	var missing []int
+	for i, bs := range bss {
+		if bs == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) > 0 {
+		filled, err := m.fill(ctx, keys, missing, fill, true)
+		if err != nil {
+			return nil, err
+		}
+		for i, bs := range filled {
+			bss[i] = bs
+		}
+	}
+	return removeNegative(bss), nil
+}
+
+func removeNegative(bss [][]byte) [][]byte {
+	for i, bs := range bss {
+		if string(bs) == negativeValue {
+			bss[i] = nil
+		}
+	}
+	return bss
+}
+
+func (m *Cache) needTTL() bool {
+	return m.config.TTL.Duration > 0 && (m.config.StaleTTL.Duration > 0 || m.config.Beta > 0)
+}
+
+//需要提前刷新时同时查询PTTL，refresh是已过期（在StaleTTL中）或需要提前刷新的
+func (m *Cache) read(ctx context.Context, keys []string) (bss [][]byte, refresh []int, err error) {
+	bss = make([][]byte, len(keys))
+	if !m.needTTL() {
+		vals, err := m.client.mget(ctx, keys...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, val := range vals {
+			if val != nil {
+				bss[i] = []byte(val.(string))
+			}
+		}
+		return bss, nil, nil
+	}
+	pipe := m.client.Pipeline()
+	defer pipe.Close()
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err = pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+	for i := range keys {
+		val, err := gets[i].Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		bss[i] = []byte(val)
+		if val == negativeValue {
+			continue //到期后再回源
+		}
+		if ttl := ttls[i].Val(); ttl > 0 && m.shouldRefresh(ttl-m.config.StaleTTL.Duration) {
+			refresh = append(refresh, i)
+		}
+	}
+	return bss, refresh, nil
+}
+
+//remaining是距离过期的时间，负数表示已过期
+func (m *Cache) shouldRefresh(remaining time.Duration) bool {
+	if remaining <= 0 {
+		return true
+	}
+	if m.config.Beta == 0 {
+		return false
+	}
+	delta := float64(atomic.LoadInt64(&m.delta))
+	return delta*m.config.Beta*-math.Log(rand.Float64()) >= float64(remaining)
+}
+
+func (m *Cache) observe(d time.Duration) {
+	old := atomic.LoadInt64(&m.delta)
+	if old == 0 {
+		atomic.StoreInt64(&m.delta, int64(d))
+		return
+	}
+	atomic.StoreInt64(&m.delta, (old*4+int64(d))/5)
+}
+
+//写入redis的ttl
+func (m *Cache) ttl() time.Duration {
+	ttl := m.config.TTL.Duration
+	if ttl == 0 {
+		return 0
+	}
+	if m.config.Jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(float64(ttl)*m.config.Jitter) + 1))
+	}
+	return ttl + m.config.StaleTTL.Duration
+}
+
+//回源并写入缓存，其他协程正在回源的key等待其结果，wait为false时跳过（后台刷新）
+func (m *Cache) fill(ctx context.Context, keys []string, idx []int, fill fillBytes, wait bool) (result map[int][]byte, err error) {
+	flights := m.client.flights
+	var leadIdx []int
+	leads := map[int]*flight{}
+	waits := map[int]*flight{}
+	for _, i := range idx {
+		f, leader := flights.claim(keys[i])
+		if leader {
+			leads[i] = f
+			leadIdx = append(leadIdx, i)
+		} else if wait {
+			waits[i] = f
+		}
+	}
+
+	result = map[int][]byte{}
+	if len(leadIdx) > 0 {
+		var filled map[int][]byte
+		func() {
+			defer func() {
+				r := recover()
+				if r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+				for i, f := range leads {
+					flights.done(keys[i], f, filled[i], err)
+				}
+				if r != nil {
+					panic(r)
+				}
+			}()
+			begin := time.Now()
+			if filled, err = fill(ctx, leadIdx); err != nil {
+				return
+			}
+			m.observe(time.Since(begin))
+			if m.config.NegativeTTL.Duration > 0 {
+				if filled == nil {
+					filled = map[int][]byte{}
+				}
+				for _, i := range leadIdx {
+					if _, ok := filled[i]; !ok {
+						filled[i] = []byte(negativeValue)
+					}
+				}
+			}
+			err = m.set(ctx, keys, filled)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		for i, bs := range filled {
+			result[i] = bs
+		}
+	}
+	for i, f := range waits {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		if f.bs != nil {
+			result[i] = f.bs
+		}
+	}
+	return result, nil
+}
+
+func (m *Cache) set(ctx context.Context, keys []string, filled map[int][]byte) error {
+	if len(filled) == 0 {
+		return nil
+	}
+	pipe := m.client.Pipeline()
+	defer pipe.Close()
+	for i, bs := range filled {
+		if string(bs) == negativeValue {
+			pipe.Set(ctx, keys[i], negativeValue, m.config.NegativeTTL.Duration)
+		} else {
+			pipe.Set(ctx, keys[i], string(bs), m.ttl())
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+//同一key同时只有一个回源
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	bs   []byte
+	err  error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{m: map[string]*flight{}}
+}
+
+//没有正在进行的回源时，当前协程成为leader，完成后必须调用done
+func (g *flightGroup) claim(key string) (f *flight, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.m[key]; ok {
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	g.m[key] = f
+	return f, true
+}
+
+func (g *flightGroup) done(key string, f *flight, bs []byte, err error) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+	f.bs, f.err = bs, err
+	close(f.done)
+}
@@ -0,0 +1,228 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	zt "zlutils/time"
+)
+
+func TestCacheGetOrFill(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	cache := client.NewCache(CacheConfig{TTL: zt.Duration{Duration: time.Minute}})
+
+	var fills int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out string
+			err := cache.GetOrFill(ctx, "k", &out, func(ctx context.Context) (interface{}, error) {
+				atomic.AddInt32(&fills, 1)
+				time.Sleep(50 * time.Millisecond)
+				return "v", nil
+			})
+			if err != nil || out != "v" {
+				t.Error(out, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fills != 1 {
+		t.Errorf("fill %d times", fills)
+	}
+
+	//回源失败
+	var out string
+	if err := cache.GetOrFill(ctx, "e", &out, func(ctx context.Context) (interface{}, error) {
+		return nil, fmt.Errorf("db down")
+	}); err == nil {
+		t.Error("want err")
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	cache := client.NewCache(CacheConfig{
+		TTL:      zt.Duration{Duration: time.Second},
+		StaleTTL: zt.Duration{Duration: time.Minute},
+	})
+	version := 0
+	fill := func(ctx context.Context) (interface{}, error) {
+		version++
+		return version, nil
+	}
+	var out int
+	if err := cache.GetOrFill(ctx, "k", &out, fill); err != nil || out != 1 {
+		t.Fatal(out, err)
+	}
+	if ttl := s.TTL("k"); ttl != time.Minute+time.Second {
+		t.Errorf("ttl %s", ttl)
+	}
+
+	s.FastForward(2 * time.Second) //已过期但还在StaleTTL中，返回旧值并在后台刷新
+	if err := cache.GetOrFill(ctx, "k", &out, fill); err != nil || out != 1 {
+		t.Fatal(out, err)
+	}
+	cache.Close() //等待后台回源结束
+	if v, _ := s.Get("k"); v != "2" {
+		t.Errorf("not refreshed %q", v)
+	}
+
+	s.FastForward(2 * time.Second) //Close后不再后台回源
+	if err := cache.GetOrFill(ctx, "k", &out, fill); err != nil || out != 2 {
+		t.Fatal(out, err)
+	}
+	cache.Close()
+	if version != 2 {
+		t.Errorf("refreshed after close %d", version)
+	}
+}
+
+func TestCacheJitter(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	cache := client.NewCache(CacheConfig{TTL: zt.Duration{Duration: 10 * time.Second}, Jitter: 0.5})
+	ttls := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("k", i)
+		var out int
+		if err := cache.GetOrFill(ctx, key, &out, func(ctx context.Context) (interface{}, error) {
+			return i, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		ttl := s.TTL(key)
+		if ttl < 10*time.Second || ttl > 15*time.Second {
+			t.Errorf("ttl %s", ttl)
+		}
+		ttls[ttl] = true
+	}
+	if len(ttls) < 2 {
+		t.Errorf("ttl not jittered %v", ttls)
+	}
+}
+
+func TestCacheMGetOrFill(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	cache := client.NewCache(CacheConfig{TTL: zt.Duration{Duration: time.Hour}})
+	s.Set("mm:1", "10")
+	var filled [][]int
+	fillFunc := func(ctx context.Context, ids []int) (map[int]*int, error) {
+		filled = append(filled, ids)
+		m := map[int]*int{}
+		for _, id := range ids {
+			if id%2 == 0 {
+				m[id] = nil //让redis保存null，避免击穿
+			} else {
+				v := id * 10
+				m[id] = &v
+			}
+		}
+		m[100] = nil //不是要查的key，忽略
+		return m, nil
+	}
+	keyFunc := func(id int) string {
+		return fmt.Sprintf("mm:%d", id)
+	}
+	for i := 0; i < 2; i++ {
+		var out map[int]*int
+		if err := cache.MGetOrFill(ctx, []int{1, 2, 3, 3}, keyFunc, fillFunc, &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 3 || *out[1] != 10 || out[2] != nil || *out[3] != 30 {
+			t.Errorf("get %v", out)
+		}
+	}
+	if got := fmt.Sprint(filled); got != "[[2 3]]" {
+		t.Errorf("filled %s", got)
+	}
+	if s.Exists("mm:100") {
+		t.Error("mm:100 should not be cached")
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	bloom := client.NewBloom(BloomConfig{Key: "bloom", Capacity: 100, FalsePositive: 0.01})
+	if err := bloom.Add(ctx, "mm:1", "mm:2"); err != nil {
+		t.Fatal(err)
+	}
+	cache := client.NewCache(CacheConfig{
+		TTL:         zt.Duration{Duration: time.Hour},
+		NegativeTTL: zt.Duration{Duration: time.Minute},
+		Bloom:       bloom,
+	})
+	var filled [][]int
+	fillFunc := func(ctx context.Context, ids []int) (map[int]int, error) {
+		filled = append(filled, ids)
+		return map[int]int{1: 10}, nil //2已经删除
+	}
+	keyFunc := func(id int) string {
+		return fmt.Sprintf("mm:%d", id)
+	}
+	for i := 0; i < 2; i++ {
+		var out map[int]int
+		if err := cache.MGetOrFill(ctx, []int{1, 2, 3}, keyFunc, fillFunc, &out); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(out) != "map[1:10]" {
+			t.Errorf("get %v", out)
+		}
+	}
+	if got := fmt.Sprint(filled); got != "[[1 2]]" { //3不在布隆过滤器中，2第二次命中占位值
+		t.Errorf("filled %s", got)
+	}
+	if ttl := s.TTL("mm:2"); ttl != time.Minute {
+		t.Errorf("ttl %s", ttl)
+	}
+
+	s.FastForward(2 * time.Minute)
+	var out int
+	err := cache.GetOrFill(ctx, "mm:2", &out, func(ctx context.Context) (interface{}, error) {
+		return nil, Nil
+	})
+	if err != Nil {
+		t.Errorf("get err %v", err)
+	}
+	if v, _ := s.Get("mm:2"); v != negativeValue {
+		t.Errorf("get %q", v)
+	}
+}
+
+func TestNegativeValueAsNil(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	s.Set("mm:1", negativeValue)
+	s.Set("mm:2", "20")
+
+	var v int
+	if err := client.GetJson(ctx, "mm:1", &v); err != Nil {
+		t.Errorf("get err %v", err)
+	}
+	var mp map[string]int
+	if err := client.MGetJsonMap(ctx, []string{"mm:1", "mm:2"}, &mp); err != nil || fmt.Sprint(mp) != "map[mm:2:20]" {
+		t.Error(mp, err)
+	}
+	var out map[int]int
+	err := client.BizMGetJsonMapWithFill(ctx, []int{1, 2}, func(id int) string {
+		return fmt.Sprintf("mm:%d", id)
+	}, func(ctx context.Context, ids []int) (map[int]int, error) {
+		if fmt.Sprint(ids) != "[1]" {
+			t.Errorf("fill %v", ids)
+		}
+		return map[int]int{1: 10}, nil
+	}, &out, time.Hour)
+	if err != nil || fmt.Sprint(out) != "map[1:10 2:20]" {
+		t.Error(out, err)
+	}
+}
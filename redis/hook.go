@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"context"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//每条命令和pipeline都打debug日志、上报metric，ctx中有xray segment时创建subsegment
+type hook struct{}
+
+type hookKey struct{}
+
+type hookState struct {
+	begin time.Time
+	seg   *xray.Segment
+}
+
+func (hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return before(ctx), nil
+}
+
+func (hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	after(ctx, cmd.Name(), []redis.Cmder{cmd})
+	return nil
+}
+
+func (hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return before(ctx), nil
+}
+
+func (hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	after(ctx, "pipeline", cmds)
+	return nil
+}
+
+func before(ctx context.Context) context.Context {
+	state := &hookState{begin: time.Now()}
+	if xray.GetSegment(ctx) != nil { //允许不传xray的ctx
+		ctx, state.seg = xray.BeginSubsegment(ctx, "redis")
+		state.seg.Namespace = "remote"
+	}
+	return context.WithValue(ctx, hookKey{}, state)
+}
+
+//name是命令名，pipeline时为pipeline，其中每条命令单独计数，耗时记在pipeline上
+func after(ctx context.Context, name string, cmds []redis.Cmder) {
+	state, ok := ctx.Value(hookKey{}).(*hookState)
+	if !ok {
+		return
+	}
+	duration := time.Since(state.begin)
+	var err error
+	var names, cmdss []string
+	for _, cmd := range cmds {
+		e := cmd.Err()
+		if e != nil && e != redis.Nil && err == nil {
+			err = e
+		}
+		names = append(names, cmd.Name())
+		cmdss = append(cmdss, cmd.String())
+		if MetricCounter != nil {
+			MetricCounter(cmd.Name(), result(e)).Inc()
+		}
+	}
+	if MetricLatency != nil {
+		MetricLatency(name).Observe(duration.Seconds() * 1000)
+	}
+	if state.seg != nil {
+		state.seg.AddMetadata("commands", names) //不记录参数，避免泄漏value
+		state.seg.Close(err)
+	}
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		field := logrus.Fields{
+			"duration": duration.String(),
+			"source":   source(),
+			"stack":    nil,
+		}
+		if len(cmds) == 1 && name != "pipeline" {
+			field["redis-cmd"] = cmdss[0]
+		} else {
+			field["redis-pipeline"] = cmdss
+		}
+		logrus.WithContext(ctx).WithFields(field).Debug()
+	}
+}
+
+func result(err error) string {
+	switch err {
+	case nil:
+		return "ok"
+	case redis.Nil:
+		return "nil"
+	default:
+		return "error"
+	}
+}
+
+var pkgPath = reflect.TypeOf(hook{}).PkgPath()
+
+//调用栈中第一个不在go-redis和本包中的函数，即业务代码
+func source() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/go-redis/") &&
+			!strings.HasPrefix(frame.Function, pkgPath+".") &&
+			!strings.HasPrefix(frame.Function, "runtime.") {
+			return frame.Function + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"strings"
+	"testing"
+	"time"
+)
+
+//记录命令执行时ctx中的xray segment
+type segmentHook struct {
+	names []string
+}
+
+func (m *segmentHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if seg := xray.GetSegment(ctx); seg != nil {
+		m.names = append(m.names, seg.Name)
+	}
+	return ctx, nil
+}
+
+func (m *segmentHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (m *segmentHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return m.BeforeProcess(ctx, cmds[0])
+}
+
+func (m *segmentHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestHook(t *testing.T) {
+	if MetricCounter == nil {
+		InitDefaultMetric("test")
+	}
+	getNil, setOk := testutil.ToFloat64(MetricCounter("get", "nil")), testutil.ToFloat64(MetricCounter("set", "ok"))
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(logrus.InfoLevel)
+	s := miniredis.RunT(t)
+	client := New("redis://" + s.Addr())
+	defer client.Close()
+	sh := &segmentHook{}
+	client.AddHook(sh)
+
+	logs := test.NewGlobal()
+	client.Get(ctx, "a") //没有xray segment
+	xctx, seg := xray.BeginSegment(ctx, "test")
+	defer seg.Close(nil)
+	client.Set(xctx, "a", "1", time.Hour)
+	if err := client.MultiSetJson(xctx, map[string]interface{}{"b": 1, "c": 2}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(sh.names, ","); got != "redis,redis" {
+		t.Errorf("get segments %s", got)
+	}
+	for _, test := range []struct {
+		cmd, result string
+		want        float64
+	}{
+		{"get", "nil", getNil + 1},
+		{"set", "ok", setOk + 3}, //pipeline中的2条也计数
+	} {
+		if got := testutil.ToFloat64(MetricCounter(test.cmd, test.result)); got != test.want {
+			t.Errorf("%s %s get %v", test.cmd, test.result, got)
+		}
+	}
+
+	//跳过go-redis和本包的调用栈，测试函数也在本包中，所以是testing.tRunner
+	n := 0
+	for _, entry := range logs.AllEntries() {
+		if entry.Data["redis-cmd"] == nil && entry.Data["redis-pipeline"] == nil {
+			continue //MultiSetJson自己的日志
+		}
+		n++
+		if source, _ := entry.Data["source"].(string); !strings.HasPrefix(source, "testing.tRunner:") {
+			t.Errorf("get source %s", source)
+		}
+	}
+	if n != 3 {
+		t.Errorf("get %d logs", n)
+	}
+}
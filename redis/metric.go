@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//redis命令一般在1ms内，不用metric.HistoryBuckets（最小10ms），在InitDefaultMetric前可替换
+var LatencyBuckets = []float64{.1, .2, .5, 1., 2., 5., 10., 20., 50., 100., 200., 500., 1000.}
+
+func InitDefaultMetric(projectName string) {
+	defaultCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_redis_total", projectName),
+			Help: "Total Redis command counts",
+		},
+		[]string{"cmd", "result"},
+	)
+	defaultLatency := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    fmt.Sprintf("%s_redis_latency_millisecond", projectName),
+			Help:    "Redis command latency (millisecond)",
+			Buckets: LatencyBuckets,
+		},
+		[]string{"cmd"},
+	)
+	prometheus.MustRegister(
+		defaultCounter,
+		defaultLatency,
+	)
+	MetricCounter = func(cmd, result string) prometheus.Counter {
+		return defaultCounter.WithLabelValues(cmd, result)
+	}
+	MetricLatency = func(cmd string) prometheus.Observer {
+		return defaultLatency.WithLabelValues(cmd)
+	}
+}
+
+var (
+	//result为ok、nil（key不存在）、error，pipeline中的每条命令单独计数
+	MetricCounter func(cmd, result string) prometheus.Counter
+	//pipeline整体的耗时cmd为pipeline
+	MetricLatency func(cmd string) prometheus.Observer
+)
@@ -4,45 +4,276 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/go-playground/validator"
+	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/redis.v5"
+	"net"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
-	"zlutils/caller"
 	"zlutils/guard"
+	zt "zlutils/time"
 )
 
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+//key不存在时返回的err
+const Nil = redis.Nil
+
+var vali = validator.New()
+
+//url和其他字段都配置时，其他非零值字段覆盖url中的，url格式：
+//
+//	单机：redis://[user:password@]host:6379[/db][?pool_size=10&read_timeout=3s]，rediss://为tls
+//	哨兵：redis-sentinel://[:password@]host1:26379,host2:26379[/db]?master=mymaster[&sentinel_password=xxx]
+//	集群：redis-cluster://[:password@]host1:6379,host2:6379[?read_only=true&route_by_latency=true]
+//
+//其他参数与go-redis的ParseURL相同
+type Config struct {
+	Url              string      `json:"url" secret:"true"`
+	Mode             string      `json:"mode" validate:"omitempty,oneof=standalone sentinel cluster"` //默认由url的scheme决定，没有url时有MasterName则为sentinel，多个Addrs则为cluster
+	Addrs            []string    `json:"addrs" validate:"required_without=Url"`
+	MasterName       string      `json:"master_name"`
+	Username         string      `json:"username"`
+	Password         string      `json:"password" secret:"true"`
+	SentinelPassword string      `json:"sentinel_password" secret:"true"`
+	DB               int         `json:"db"` //集群不支持
+	PoolSize         int         `json:"pool_size"`
+	MinIdleConns     int         `json:"min_idle_conns"`
+	DialTimeout      zt.Duration `json:"dial_timeout"`
+	ReadTimeout      zt.Duration `json:"read_timeout"`
+	WriteTimeout     zt.Duration `json:"write_timeout"`
+	ReadOnly         bool        `json:"read_only"`        //集群时从从节点读
+	RouteByLatency   bool        `json:"route_by_latency"` //集群时读延迟最低的节点，隐含ReadOnly
+}
+
 //NOTE: 只能用于初始化时候，失败则panic
-//redis基本不会是性能瓶颈，所以不放xray
-func New(url string) (client *Client) {
-	redisOpt, err := redis.ParseURL(url)
+func New(url string) *Client {
+	return NewWithConfig(Config{Url: url})
+}
+
+//NOTE: 只能用于初始化时候，失败则panic
+func NewWithConfig(config Config) (client *Client) {
+	entry := logrus.WithFields(config.logFields())
+	if err := vali.Struct(config); err != nil {
+		entry.WithError(err).Panic("redis config invalid")
+	}
+	opt, mode, err := config.options()
 	if err != nil {
-		logrus.WithError(err).Panic("redis connect failed")
-	}
-	client = &Client{redis.NewClient(redisOpt)}
-	//NOTE: pipeline没法用这个打日志
-	client.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
-		return func(cmd redis.Cmder) error {
-			begin := time.Now()
-			err := oldProcess(cmd)
-			end := time.Now()
-			logrus.WithFields(logrus.Fields{
-				"redis-cmd": cmd.String(),
-				"duration":  end.Sub(begin).String(),
-				"source":    caller.Caller(5), //FIXME: 这个数不太准
-				"stack":     nil,
-			}).Debug()
-			return err
-		}
-	})
+		entry.WithError(err).Panic("redis connect failed")
+	}
+	switch mode {
+	case ModeSentinel:
+		client = &Client{UniversalClient: redis.NewFailoverClient(opt.Failover()), mode: mode, flights: newFlightGroup()}
+	case ModeCluster:
+		client = &Client{UniversalClient: redis.NewClusterClient(opt.Cluster()), mode: mode, flights: newFlightGroup()}
+	default:
+		client = &Client{UniversalClient: redis.NewClient(opt.Simple()), mode: mode, flights: newFlightGroup()}
+	}
+	client.AddHook(hook{})
 	return
 }
 
+//打日志用，不含url中的密码和Password等secret字段
+func (config Config) logFields() logrus.Fields {
+	fields := logrus.Fields{
+		"mode":        config.Mode,
+		"addrs":       config.Addrs,
+		"master_name": config.MasterName,
+		"db":          config.DB,
+	}
+	if u, err := url.Parse(config.Url); err == nil {
+		fields["url_host"] = u.Host
+	}
+	return fields
+}
+
+//解析url并用其他字段覆盖
+func (config Config) options() (opt *redis.UniversalOptions, mode string, err error) {
+	opt = &redis.UniversalOptions{}
+	if config.Url != "" {
+		if opt, mode, err = parseURL(config.Url); err != nil {
+			return
+		}
+	}
+	if config.Mode != "" {
+		mode = config.Mode
+	}
+	if len(config.Addrs) > 0 {
+		opt.Addrs = config.Addrs
+	}
+	if config.MasterName != "" {
+		opt.MasterName = config.MasterName
+	}
+	if config.Username != "" {
+		opt.Username = config.Username
+	}
+	if config.Password != "" {
+		opt.Password = config.Password
+	}
+	if config.SentinelPassword != "" {
+		opt.SentinelPassword = config.SentinelPassword
+	}
+	if config.DB != 0 {
+		opt.DB = config.DB
+	}
+	if config.PoolSize != 0 {
+		opt.PoolSize = config.PoolSize
+	}
+	if config.MinIdleConns != 0 {
+		opt.MinIdleConns = config.MinIdleConns
+	}
+	if config.DialTimeout.Duration != 0 {
+		opt.DialTimeout = config.DialTimeout.Duration
+	}
+	if config.ReadTimeout.Duration != 0 {
+		opt.ReadTimeout = config.ReadTimeout.Duration
+	}
+	if config.WriteTimeout.Duration != 0 {
+		opt.WriteTimeout = config.WriteTimeout.Duration
+	}
+	opt.ReadOnly = opt.ReadOnly || config.ReadOnly
+	opt.RouteByLatency = opt.RouteByLatency || config.RouteByLatency
+	if mode == "" {
+		if opt.MasterName != "" {
+			mode = ModeSentinel
+		} else if len(opt.Addrs) > 1 {
+			mode = ModeCluster
+		} else {
+			mode = ModeStandalone
+		}
+	}
+	if len(opt.Addrs) == 0 {
+		return nil, "", fmt.Errorf("redis addrs is empty")
+	}
+	if mode == ModeSentinel && opt.MasterName == "" {
+		return nil, "", fmt.Errorf("redis sentinel master name is empty")
+	}
+	return opt, mode, nil
+}
+
+func parseURL(rawURL string) (opt *redis.UniversalOptions, mode string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok { //不带url，避免打印密码
+			err = fmt.Errorf("redis: invalid URL: %s", e.Err)
+		}
+		return nil, "", err
+	}
+	scheme, defaultPort := "redis", "6379"
+	switch u.Scheme {
+	case "redis", "rediss":
+		mode, scheme = ModeStandalone, u.Scheme
+	case "redis-sentinel":
+		mode, defaultPort = ModeSentinel, "26379"
+	case "redis-cluster":
+		mode = ModeCluster
+	default:
+		return nil, "", fmt.Errorf("redis: invalid URL scheme: %s", u.Scheme)
+	}
+	//go-redis不认识的参数先取出来
+	q := u.Query()
+	extra := map[string]string{}
+	for _, name := range []string{"master", "sentinel_password", "read_only", "route_by_latency", "route_randomly"} {
+		extra[name] = q.Get(name)
+		q.Del(name)
+	}
+	var addrs []string
+	for _, host := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, defaultPort)
+		}
+		addrs = append(addrs, host)
+	}
+	single := *u
+	single.Scheme, single.Host, single.RawQuery = scheme, addrs[0], q.Encode()
+	o, err := redis.ParseURL(single.String())
+	if err != nil {
+		return nil, "", err
+	}
+	opt = &redis.UniversalOptions{
+		Addrs:              addrs,
+		DB:                 o.DB,
+		Username:           o.Username,
+		Password:           o.Password,
+		SentinelPassword:   extra["sentinel_password"],
+		MaxRetries:         o.MaxRetries,
+		MinRetryBackoff:    o.MinRetryBackoff,
+		MaxRetryBackoff:    o.MaxRetryBackoff,
+		DialTimeout:        o.DialTimeout,
+		ReadTimeout:        o.ReadTimeout,
+		WriteTimeout:       o.WriteTimeout,
+		PoolFIFO:           o.PoolFIFO,
+		PoolSize:           o.PoolSize,
+		MinIdleConns:       o.MinIdleConns,
+		MaxConnAge:         o.MaxConnAge,
+		PoolTimeout:        o.PoolTimeout,
+		IdleTimeout:        o.IdleTimeout,
+		IdleCheckFrequency: o.IdleCheckFrequency,
+		TLSConfig:          o.TLSConfig,
+		MasterName:         extra["master"],
+	}
+	for name, ptr := range map[string]*bool{
+		"read_only":        &opt.ReadOnly,
+		"route_by_latency": &opt.RouteByLatency,
+		"route_randomly":   &opt.RouteRandomly,
+	} {
+		if extra[name] == "" {
+			continue
+		}
+		if *ptr, err = strconv.ParseBool(extra[name]); err != nil {
+			return nil, "", fmt.Errorf("redis: invalid %s boolean: %s", name, err)
+		}
+	}
+	return opt, mode, nil
+}
+
+//单机、哨兵、集群都用这个，所有命令都传ctx，ctx的超时、取消对命令生效
 type Client struct {
-	*redis.Client
+	redis.UniversalClient
+	mode    string
+	flights *flightGroup //Cache回源用
+}
+
+func (client *Client) Mode() string {
+	return client.mode
+}
+
+//集群中MGET的key必须在同一个slot，所以改成pipeline GET，会按节点分组执行，
+//与MGET相同，不存在的key对应nil
+func (client *Client) mget(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if client.mode != ModeCluster {
+		return client.MGet(ctx, keys...).Result()
+	}
+	pipe := client.Pipeline()
+	defer pipe.Close()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
 }
 
-//TODO: 要加ctx的话就用WithContext
 func (client *Client) SetJson(ctx context.Context, key string, value interface{}, expiration time.Duration) (err error) {
 	defer guard.BeforeCtx(&ctx)(&err)
 	entry := logrus.WithContext(ctx).WithFields(logrus.Fields{
@@ -55,7 +286,7 @@ func (client *Client) SetJson(ctx context.Context, key string, value interface{}
 		entry.WithError(err).Error()
 		return
 	}
-	cmd := client.Set(key, string(bs), expiration)
+	cmd := client.Set(ctx, key, string(bs), expiration)
 	entry = entry.WithField("cmd", cmd.String())
 	if err = cmd.Err(); err != nil {
 		entry.WithError(err).Error()
@@ -67,7 +298,7 @@ func (client *Client) SetJson(ctx context.Context, key string, value interface{}
 func (client *Client) GetJson(ctx context.Context, key string, value interface{}) (err error) {
 	defer guard.BeforeCtx(&ctx)(&err)
 	entry := logrus.WithContext(ctx).WithField("key", key)
-	cmd := client.Get(key)
+	cmd := client.Get(ctx, key)
 	entry = entry.WithField("cmd", cmd.String())
 	if err = cmd.Err(); err != nil {
 		if err != redis.Nil { //NOTE: nil不打err日志
@@ -75,6 +306,9 @@ func (client *Client) GetJson(ctx context.Context, key string, value interface{}
 		}
 		return
 	}
+	if cmd.Val() == negativeValue { //Cache保存的不存在的key
+		return Nil
+	}
 	if err = json.Unmarshal([]byte(cmd.Val()), value); err != nil {
 		entry.WithError(err).Error()
 		return
@@ -155,23 +389,38 @@ func checkOut(outPtrType, bizKeyType reflect.Type) error {
 入参解释：
 1. `bizKeys`必须是`slice`
 2. `keyFunc`必须只有一个入参和一个出参，
- * 入参类型必须与`bizKeys`的数组内每个元素的类型相同
- * 出参类型必须是`string`
+  - 入参类型必须与`bizKeys`的数组内每个元素的类型相同
+  - 出参类型必须是`string`
+
 3. `fillFunc`(不为nil时)必须是2个入参，2个出参
- * 入参顺序：
-  1. `ctx context.Context`
-  2. `noCachedBizKeys` 未命中的`bizKey`数组，类型必须与`bizKeys`相同
- * 出参顺序：
-  1. `noCachedMap map[bizKey类型]bizValue类型`
-  2. `err error` 发生错误时，会返回
+  - 入参顺序：
+    1. `ctx context.Context`
+    2. `noCachedBizKeys` 未命中的`bizKey`数组，类型必须与`bizKeys`相同
+  - 出参顺序：
+    1. `noCachedMap map[bizKey类型]bizValue类型`
+    2. `err error` 发生错误时，会返回
+
 4. `outPtr` 必须是`map[bizKey类型]bizValue类型`的地址
 */
+//NOTE: 并发时同一key可能多次回源，需要singleflight、提前刷新、TTL抖动、布隆过滤器时用NewCache
 func (client *Client) BizMGetJsonMapWithFill(ctx context.Context, bizKeys, keyFunc, fillFunc, outPtr interface{}, expiration time.Duration) (err error) {
 	defer guard.BeforeCtx(&ctx)(&err)
+	return client.bizMGetJsonMapWithFill(ctx, bizKeys, keyFunc, fillFunc, outPtr, expiration, 0)
+}
+
+//与BizMGetJsonMapWithFill相同，fillFunc没有返回的key保存一个占位值，过期时间为negativeExpiration，
+//期间不再回源，避免缓存穿透，outPtr中依然没有这个key，negativeExpiration为0时与BizMGetJsonMapWithFill相同
+func (client *Client) BizMGetJsonMapWithFillNegative(ctx context.Context, bizKeys, keyFunc, fillFunc, outPtr interface{}, expiration, negativeExpiration time.Duration) (err error) {
+	defer guard.BeforeCtx(&ctx)(&err)
+	return client.bizMGetJsonMapWithFill(ctx, bizKeys, keyFunc, fillFunc, outPtr, expiration, negativeExpiration)
+}
+
+func (client *Client) bizMGetJsonMapWithFill(ctx context.Context, bizKeys, keyFunc, fillFunc, outPtr interface{}, expiration, negativeExpiration time.Duration) (err error) {
 	entry := logrus.WithContext(ctx).
 		WithFields(logrus.Fields{
-			"bizKeys":    bizKeys,
-			"expiration": expiration,
+			"bizKeys":            bizKeys,
+			"expiration":         expiration,
+			"negativeExpiration": negativeExpiration,
 		})
 
 	//检查bizKeys类型
@@ -220,9 +469,8 @@ func (client *Client) BizMGetJsonMapWithFill(ctx context.Context, bizKeys, keyFu
 	}
 	entry = entry.WithField("redisKeys", redisKeys)
 
-	cmds := client.MGet(redisKeys...)
-	entry = entry.WithField("mget-cmds", cmds.String())
-	if err = cmds.Err(); err != nil {
+	vals, err := client.mget(ctx, redisKeys...)
+	if err != nil {
 		entry.WithError(err).Error()
 		return
 	}
@@ -231,8 +479,11 @@ func (client *Client) BizMGetJsonMapWithFill(ctx context.Context, bizKeys, keyFu
 
 	cachedMapValue := reflect.ValueOf(outPtr).Elem()
 	cachedMapValue.Set(reflect.MakeMap(cachedMapValue.Type()))
-	for i, val := range cmds.Val() {
-		if val == nil {
+	for i, val := range vals {
+		if val == negativeValue && negativeExpiration > 0 {
+			continue //已知不存在
+		}
+		if val == nil || val == negativeValue {
 			noCachedBizKeysValue = reflect.Append(noCachedBizKeysValue, bizKeysValue.Index(i))
 		} else {
 			newValuePtr := reflect.New(bizValueType).Interface()
@@ -253,10 +504,11 @@ func (client *Client) BizMGetJsonMapWithFill(ctx context.Context, bizKeys, keyFu
 			return
 		}
 		noCachedMapValue := out[0]
-		if noCachedMapValue.Len() > 0 {
+		if noCachedMapValue.Len() > 0 || negativeExpiration > 0 {
 			pipe := client.Pipeline()
 			defer pipe.Close()
 
+			filled := map[string]bool{}
 			for iter := noCachedMapValue.MapRange(); iter.Next(); {
 				cachedMapValue.SetMapIndex(iter.Key(), iter.Value())
 				bs, err := json.Marshal(iter.Value().Interface())
@@ -265,9 +517,19 @@ func (client *Client) BizMGetJsonMapWithFill(ctx context.Context, bizKeys, keyFu
 					return err
 				}
 				redisKey := keyFuncValue.Call([]reflect.Value{iter.Key()})[0].String()
-				pipe.Set(redisKey, string(bs), expiration)
+				pipe.Set(ctx, redisKey, string(bs), expiration)
+				filled[redisKey] = true
+			}
+			if negativeExpiration > 0 {
+				for i := 0; i < noCachedBizKeysValue.Len(); i++ {
+					redisKey := keyFuncValue.Call([]reflect.Value{noCachedBizKeysValue.Index(i)})[0].String()
+					if !filled[redisKey] {
+						pipe.Set(ctx, redisKey, negativeValue, negativeExpiration)
+						filled[redisKey] = true
+					}
+				}
 			}
-			cmds, err := pipe.Exec()
+			cmds, err := pipe.Exec(ctx)
 
 			var cmdss []string
 			for _, cmd := range cmds {
@@ -307,15 +569,14 @@ func (client *Client) MGetJsonMap(ctx context.Context, keys []string, mapPtr int
 	}
 
 	entry := logrus.WithContext(ctx).WithField("keys", keys)
-	cmds := client.MGet(keys...)
-	entry = entry.WithField("cmds", cmds.String())
-	if err = cmds.Err(); err != nil {
+	vals, err := client.mget(ctx, keys...)
+	if err != nil {
 		entry.WithError(err).Error()
 		return
 	}
 	mp.Set(reflect.MakeMap(mp.Type()))
-	for i, val := range cmds.Val() {
-		if val == nil {
+	for i, val := range vals {
+		if val == nil || val == negativeValue {
 			continue
 		}
 		newValuePtr := reflect.New(valueType).Interface()
@@ -346,10 +607,10 @@ func (client *Client) MultiSetJson(ctx context.Context, mp map[string]interface{
 			entry.WithError(err).Error()
 			return err
 		}
-		pipe.Set(k, string(bs), expiration)
+		pipe.Set(ctx, k, string(bs), expiration)
 	}
 
-	cmds, err := pipe.Exec()
+	cmds, err := pipe.Exec(ctx)
 
 	var cmdss []string
 	for _, cmd := range cmds {
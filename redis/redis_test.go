@@ -3,7 +3,10 @@ package redis
 import (
 	"context"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"strings"
 	"testing"
 	"time"
 	"zlutils/logger"
@@ -80,3 +83,184 @@ func TestClient_BizMGetJsonMap(t *testing.T) {
 	}
 	fmt.Println(out)
 }
+
+//与引入Cache之前的行为一致
+func TestBizMGetJsonMapWithFill(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	s.Set("mm:1", "10")
+	keyFunc := func(id int) string {
+		return fmt.Sprintf("mm:%d", id)
+	}
+	var filled [][]int
+	fillFunc := func(ctx context.Context, ids []int) (map[int]*int, error) {
+		filled = append(filled, ids)
+		m := map[int]*int{}
+		for _, id := range ids {
+			if id%2 == 0 {
+				m[id] = nil
+			} else {
+				v := id * 10
+				m[id] = &v
+			}
+		}
+		m[100] = nil //不是要查的key，也会写入outPtr和redis
+		return m, nil
+	}
+	var out map[int]*int
+	if err := client.BizMGetJsonMapWithFill(ctx, []int{1, 2, 3, 3}, keyFunc, fillFunc, &out, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 4 || *out[1] != 10 || out[2] != nil || *out[3] != 30 || out[100] != nil {
+		t.Errorf("get %v", out)
+	}
+	if got := fmt.Sprint(filled); got != "[[2 3 3]]" { //重复的key不去重
+		t.Errorf("filled %s", got)
+	}
+	for key, want := range map[string]string{"mm:2": "null", "mm:3": "30", "mm:100": "null"} {
+		if v, _ := s.Get(key); v != want {
+			t.Errorf("%s get %q want %q", key, v, want)
+		}
+		if ttl := s.TTL(key); ttl != time.Hour {
+			t.Errorf("%s ttl %s", key, ttl)
+		}
+	}
+
+	//回源失败时返回错误，不写入redis
+	err := client.BizMGetJsonMapWithFill(ctx, []int{5}, keyFunc, func(ctx context.Context, ids []int) (map[int]*int, error) {
+		return nil, fmt.Errorf("db down")
+	}, &out, time.Hour)
+	if err == nil || err.Error() != "db down" {
+		t.Errorf("get err %v", err)
+	}
+	if s.Exists("mm:5") {
+		t.Error("mm:5 should not be cached")
+	}
+}
+
+func TestBizMGetJsonMapWithFillNegative(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := NewWithConfig(Config{Addrs: []string{s.Addr()}})
+	keyFunc := func(id int) string {
+		return fmt.Sprintf("mm:%d", id)
+	}
+	var filled [][]int
+	fillFunc := func(ctx context.Context, ids []int) (map[int]int, error) {
+		filled = append(filled, ids)
+		return map[int]int{1: 10}, nil //2不存在
+	}
+	for i := 0; i < 2; i++ {
+		var out map[int]int
+		if err := client.BizMGetJsonMapWithFillNegative(ctx, []int{1, 2}, keyFunc, fillFunc, &out, time.Hour, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(out) != "map[1:10]" {
+			t.Errorf("get %v", out)
+		}
+	}
+	if got := fmt.Sprint(filled); got != "[[1 2]]" { //第二次命中占位值不再回源
+		t.Errorf("filled %s", got)
+	}
+	if v, _ := s.Get("mm:2"); v != negativeValue {
+		t.Errorf("get %q", v)
+	}
+	if ttl := s.TTL("mm:2"); ttl != time.Minute {
+		t.Errorf("ttl %s", ttl)
+	}
+
+	s.FastForward(2 * time.Minute) //占位值过期后再回源
+	var out map[int]int
+	if err := client.BizMGetJsonMapWithFillNegative(ctx, []int{2}, keyFunc, fillFunc, &out, time.Hour, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(filled); got != "[[1 2] [2]]" {
+		t.Errorf("filled %s", got)
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	for _, test := range []struct {
+		config Config
+		want   string
+	}{
+		{Config{Url: "redis://:pwd@localhost:6379/2?pool_size=5"}, "standalone [localhost:6379] db=2 pwd=pwd pool=5 master= ro=false"},
+		{Config{Url: "redis-sentinel://:pwd@s1,s2:26380/1?master=mymaster&sentinel_password=spwd"}, "sentinel [s1:26379 s2:26380] db=1 pwd=pwd pool=0 master=mymaster ro=false"},
+		{Config{Url: "redis-cluster://c1:7000,c2:7001?read_only=true", PoolSize: 20}, "cluster [c1:7000 c2:7001] db=0 pwd= pool=20 master= ro=true"},
+		{Config{Addrs: []string{"c1:7000", "c2:7001"}}, "cluster [c1:7000 c2:7001] db=0 pwd= pool=0 master= ro=false"},
+		{Config{Addrs: []string{"c1:7000"}, Mode: ModeCluster}, "cluster [c1:7000] db=0 pwd= pool=0 master= ro=false"},
+		{Config{Addrs: []string{"s1:26379"}, MasterName: "mymaster"}, "sentinel [s1:26379] db=0 pwd= pool=0 master=mymaster ro=false"},
+	} {
+		opt, mode, err := test.config.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := fmt.Sprintf("%s %v db=%d pwd=%s pool=%d master=%s ro=%v", mode, opt.Addrs, opt.DB, opt.Password, opt.PoolSize, opt.MasterName, opt.ReadOnly)
+		if got != test.want {
+			t.Errorf("get %s want %s", got, test.want)
+		}
+	}
+	for _, config := range []Config{
+		{Url: "http://localhost"},
+		{Url: "redis-sentinel://s1:26379"}, //没有master
+		{Url: "redis-cluster://c1,c2?read_only=x"},
+		{Url: "redis://localhost?unknown=1"},
+	} {
+		if _, _, err := config.options(); err == nil {
+			t.Errorf("%s want err", config.Url)
+		}
+	}
+}
+
+func TestClientMode(t *testing.T) {
+	s := miniredis.RunT(t)
+	for _, client := range []*Client{
+		New("redis://" + s.Addr()),
+		NewWithConfig(Config{Addrs: []string{s.Addr()}, Mode: ModeCluster}), //miniredis是只有一个节点的集群
+	} {
+		if err := client.MultiSetJson(ctx, map[string]interface{}{"b": 1, "c": "b"}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		var mp map[string]interface{}
+		if err := client.MGetJsonMap(ctx, []string{"b", "c", "d"}, &mp); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(mp); got != "map[b:1 c:b]" {
+			t.Errorf("%s get %s", client.Mode(), got)
+		}
+		var v int
+		if err := client.GetJson(ctx, "d", &v); err != Nil {
+			t.Errorf("%s get err %v", client.Mode(), err)
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := client.GetJson(canceled, "b", &v); err != context.Canceled {
+			t.Errorf("%s get err %v", client.Mode(), err)
+		}
+		client.Close()
+	}
+}
+
+func TestConfigLogNoSecret(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	for _, config := range []Config{
+		{Url: "redis://:secret@localhost:bad"}, //url.Parse的错误中带有url
+		{Url: "redis-sentinel://:secret@s1:26379", Password: "secret", SentinelPassword: "secret"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v want panic", config)
+				}
+			}()
+			NewWithConfig(config)
+		}()
+	}
+	for _, entry := range hook.AllEntries() {
+		s, _ := entry.String()
+		if strings.Contains(s, "secret") {
+			t.Errorf("secret in log: %s", s)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package request
+
+import (
+	"context"
+	"sync"
+	"zlutils/guard"
+)
+
+//DoBatch中的一次调用
+type Call struct {
+	Request
+	RespBody RespBodyI
+	Err      error //执行后设置
+}
+
+//并发调用，concurrency<=0则不限制并发数
+//任何一个调用出错，则取消其他调用（通过ctx），并返回第一个错误
+func DoBatch(ctx context.Context, concurrency int, calls ...*Call) (err error) {
+	defer guard.BeforeCtx(&ctx)(&err)
+	return doBatch(ctx, concurrency, true, calls)
+}
+
+//并发调用，所有调用都执行完才返回，各自的错误在Call.Err中，返回第一个错误
+func DoBatchAll(ctx context.Context, concurrency int, calls ...*Call) (err error) {
+	defer guard.BeforeCtx(&ctx)(&err)
+	return doBatch(ctx, concurrency, false, calls)
+}
+
+func doBatch(ctx context.Context, concurrency int, failFast bool, calls []*Call) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if concurrency <= 0 || concurrency > len(calls) {
+		concurrency = len(calls)
+	}
+	sem := make(chan struct{}, concurrency)
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+	for _, call := range calls {
+		call := call
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				call.Err = ctx.Err() //已被取消，不再调用
+				return
+			}
+			if call.Err = call.Do(ctx, call.RespBody); call.Err != nil {
+				once.Do(func() {
+					err = call.Err
+					if failFast {
+						cancel()
+					}
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return
+}
@@ -0,0 +1,81 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoBatch(t *testing.T) {
+	var underway, maxUnderway int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&underway, 1)
+		defer atomic.AddInt32(&underway, -1)
+		for {
+			max := atomic.LoadInt32(&maxUnderway)
+			if n <= max || atomic.CompareAndSwapInt32(&maxUnderway, max, n) {
+				break
+			}
+		}
+		if r.URL.Query().Get("ret") != "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(w, `{"ret":0,"msg":"%s"}`, r.URL.Query().Get("i"))
+	}))
+	defer ts.Close()
+
+	newCalls := func(bad int) (calls []*Call) {
+		for i := 0; i < 6; i++ {
+			ret := 0
+			if i == bad {
+				ret = 1
+			}
+			calls = append(calls, &Call{
+				Request: Request{
+					Config: Config{Method: http.MethodGet, Url: ts.URL},
+					Query:  MSI{"i": i, "ret": ret},
+				},
+				RespBody: &RespRet{},
+			})
+		}
+		return
+	}
+
+	calls := newCalls(-1)
+	if err := DoBatch(ctx, 2, calls...); err != nil {
+		t.Fatal(err)
+	}
+	for i, call := range calls {
+		if msg := call.RespBody.(*RespRet).Msg; msg != fmt.Sprint(i) {
+			t.Errorf("%d get msg %s", i, msg)
+		}
+	}
+	if maxUnderway > 2 {
+		t.Errorf("get max underway %d want <= 2", maxUnderway)
+	}
+
+	calls = newCalls(0)
+	if err := DoBatchAll(ctx, 0, calls...); err == nil {
+		t.Fatal("want err")
+	}
+	for i, call := range calls[1:] {
+		if call.Err != nil {
+			t.Errorf("%d get err %v", i+1, call.Err)
+		}
+	}
+
+	calls = newCalls(0)
+	if err := DoBatch(ctx, 0, calls...); err == nil {
+		t.Fatal("want err")
+	}
+	for i, call := range calls[1:] {
+		if call.Err == nil {
+			t.Errorf("%d should be canceled", i+1)
+		}
+	}
+}
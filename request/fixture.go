@@ -0,0 +1,234 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	FixtureModeRecord = "record" //真实请求，并把请求/响应保存到文件
+	FixtureModeReplay = "replay" //不发请求，从文件读取响应
+)
+
+//录制/回放请求，用于测试时不依赖下游
+type Fixture struct {
+	Mode    string   //record或replay
+	Dir     string   //文件目录
+	Headers []string //参与匹配的header，method、url、body总是参与匹配
+
+	mu sync.Mutex //录制时并发写同一个文件
+}
+
+var fixture *Fixture
+
+//测试时通过环境变量开启，例如：
+//REQUEST_FIXTURE_MODE=record REQUEST_FIXTURE_DIR=testdata/fixtures go test
+func init() {
+	if mode := os.Getenv("REQUEST_FIXTURE_MODE"); mode != "" {
+		var headers []string
+		if s := os.Getenv("REQUEST_FIXTURE_HEADERS"); s != "" { //逗号分隔
+			headers = strings.Split(s, ",")
+		}
+		SetFixture(&Fixture{
+			Mode:    mode,
+			Dir:     os.Getenv("REQUEST_FIXTURE_DIR"),
+			Headers: headers,
+		})
+	}
+}
+
+//传nil则关闭
+//NOTE: 非并发安全，只能用于初始化时候
+func SetFixture(f *Fixture) {
+	if f != nil {
+		if f.Mode != FixtureModeRecord && f.Mode != FixtureModeReplay {
+			logrus.WithField("fixture", f).Panicf("invalid fixture mode:%s", f.Mode)
+		}
+		if f.Dir == "" {
+			f.Dir = "testdata/fixtures"
+		}
+	}
+	fixture = f
+}
+
+type fixtureRecord struct {
+	Request struct {
+		Method string      `json:"method"`
+		Url    string      `json:"url"`
+		Header http.Header `json:"header"`
+		Body   string      `json:"body"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header"`
+		Body       string      `json:"body"`
+	} `json:"response"`
+}
+
+//服务发现之前的url，用它匹配文件，避免解析到不同实例时匹配不上
+type fixtureUrlKey struct{}
+
+func withFixtureUrl(ctx context.Context, url string) context.Context {
+	return context.WithValue(ctx, fixtureUrlKey{}, url)
+}
+
+type fixtureTransport struct {
+	*Fixture
+	next http.RoundTripper
+}
+
+func (m *Fixture) wrap(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport: &fixtureTransport{Fixture: m, next: next},
+		Timeout:   client.Timeout,
+	}
+}
+
+func (m *fixtureTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var reqBodyBs []byte
+	if request.Body != nil {
+		var err error
+		if reqBodyBs, err = ioutil.ReadAll(request.Body); err != nil {
+			return nil, err
+		}
+		request.Body.Close()
+		request.Body = ioutil.NopCloser(bytes.NewReader(reqBodyBs))
+	}
+	var record fixtureRecord
+	record.Request.Method = request.Method
+	record.Request.Url = request.URL.String()
+	if url, ok := request.Context().Value(fixtureUrlKey{}).(string); ok {
+		record.Request.Url = url
+	}
+	record.Request.Header = http.Header{}
+	for _, k := range m.Headers {
+		if v, ok := request.Header[http.CanonicalHeaderKey(k)]; ok {
+			record.Request.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	record.Request.Body = string(reqBodyBs)
+	file := filepath.Join(m.Dir, m.key(record)+".json")
+	entry := logrus.WithFields(logrus.Fields{
+		"file":   file,
+		"method": record.Request.Method,
+		"url":    record.Request.Url,
+	})
+
+	if m.Mode == FixtureModeReplay {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			err = fmt.Errorf("fixture not found, record it first: %s", err)
+			entry.WithError(err).Error()
+			return nil, err
+		}
+		if err = json.Unmarshal(bs, &record); err != nil {
+			entry.WithError(err).Error()
+			return nil, err
+		}
+		return &http.Response{
+			Status:        http.StatusText(record.Response.StatusCode),
+			StatusCode:    record.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        record.Response.Header,
+			Body:          ioutil.NopCloser(strings.NewReader(record.Response.Body)),
+			ContentLength: int64(len(record.Response.Body)),
+			Request:       request,
+		}, nil
+	}
+
+	resp, err := m.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	record.Response.StatusCode = resp.StatusCode
+	record.Response.Header = resp.Header
+	//不预先读完body，避免影响流式读取，body读完并关闭时保存
+	resp.Body = &recordBody{ReadCloser: resp.Body, entry: entry, save: func(body []byte) error {
+		record.Response.Body = string(body)
+		return m.save(file, record, entry)
+	}}
+	return resp, nil
+}
+
+func (m *Fixture) save(file string, record fixtureRecord, entry *logrus.Entry) error {
+	bs, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		entry.WithError(err).Error()
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = os.MkdirAll(m.Dir, 0755); err != nil {
+		entry.WithError(err).Error()
+		return err
+	}
+	if err = ioutil.WriteFile(file, bs, 0644); err != nil {
+		entry.WithError(err).Error()
+		return err
+	}
+	entry.Debug("fixture recorded")
+	return nil
+}
+
+//边读边复制
+type recordBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	eof   bool //没读完就关闭时body不完整，不保存，否则回放时会当作完整的响应
+	entry *logrus.Entry
+	save  func(body []byte) error
+	once  sync.Once
+}
+
+func (m *recordBody) Read(p []byte) (n int, err error) {
+	n, err = m.ReadCloser.Read(p)
+	m.buf.Write(p[:n])
+	if err == io.EOF {
+		m.eof = true
+	}
+	return
+}
+
+func (m *recordBody) Close() error {
+	err := m.ReadCloser.Close()
+	m.once.Do(func() {
+		if !m.eof {
+			m.entry.Warn("fixture not recorded, response body closed before EOF")
+			return
+		}
+		if e := m.save(m.buf.Bytes()); e != nil && err == nil {
+			err = e
+		}
+	})
+	return err
+}
+
+//method、url、选中的header、body相同则key相同
+func (m *Fixture) key(record fixtureRecord) string {
+	h := sha1.New()
+	fmt.Fprintln(h, record.Request.Method)
+	fmt.Fprintln(h, record.Request.Url)
+	for _, k := range m.Headers {
+		fmt.Fprintln(h, k, record.Request.Header[http.CanonicalHeaderKey(k)])
+	}
+	h.Write([]byte(record.Request.Body))
+	return hex.EncodeToString(h.Sum(nil))
+}
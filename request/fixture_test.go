@@ -0,0 +1,146 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetFixture(nil)
+
+	ts := NewMockServer()
+	ts.On(http.MethodPost, "/add").Reply(http.StatusOK, MSI{"ret": 0, "msg": "recorded"})
+	req := Request{
+		Config: Config{Method: http.MethodPost, Url: ts.URL + "/add"},
+		Header: MSI{"User-Id": 1},
+		Body:   MSI{"like": 1},
+	}
+
+	SetFixture(&Fixture{Mode: FixtureModeRecord, Dir: dir, Headers: []string{"User-Id"}})
+	var resp RespRet
+	if err := req.Do(ctx, &resp); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	SetFixture(&Fixture{Mode: FixtureModeReplay, Dir: dir, Headers: []string{"User-Id"}})
+	resp = RespRet{}
+	if err := req.Do(ctx, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Msg != "recorded" {
+		t.Errorf("get msg %s want recorded", resp.Msg)
+	}
+
+	req.Header = MSI{"User-Id": 2} //header不同则匹配不到
+	if err := req.Do(ctx, &resp); err == nil {
+		t.Error("want fixture not found")
+	}
+}
+
+type fixtureResolver struct {
+	host string
+}
+
+func (m fixtureResolver) Resolve(ctx context.Context, service string) (string, func(err error), error) {
+	if m.host == "" {
+		return "", nil, fmt.Errorf("no instance")
+	}
+	return m.host, func(error) {}, nil
+}
+
+func TestFixtureResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetFixture(nil)
+	defer delete(resolvers, "fixture")
+
+	ts := NewMockServer()
+	ts.On(http.MethodGet, "/export").Reply(http.StatusOK, MSI{"ret": 0, "msg": "streamed"})
+	RegisterResolver("fixture", fixtureResolver{host: strings.TrimPrefix(ts.URL, "http://")})
+	req := Request{Config: Config{Method: http.MethodGet, Url: "fixture://hello/export"}}
+	read := func() (string, error) {
+		var body []byte
+		err := req.DoStream(ctx, func(header http.Header, r io.Reader) (err error) {
+			body, err = ioutil.ReadAll(r)
+			return
+		})
+		return string(body), err
+	}
+
+	SetFixture(&Fixture{Mode: FixtureModeRecord, Dir: dir})
+	if _, err := read(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	//回放时用服务名匹配，不需要解析地址
+	RegisterResolver("fixture", fixtureResolver{})
+	SetFixture(&Fixture{Mode: FixtureModeReplay, Dir: dir})
+	body, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "streamed") {
+		t.Errorf("get body %s", body)
+	}
+}
+
+func TestFixtureRecordIncomplete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetFixture(nil)
+
+	ts := NewMockServer()
+	defer ts.Close()
+	var list []MSI
+	for i := 0; i < 1000; i++ { //比bufio的缓冲大，提前返回时读不到EOF
+		list = append(list, MSI{"a": i, "s": strings.Repeat("x", 100)})
+	}
+	ts.On(http.MethodGet, "/list").Reply(http.StatusOK, list)
+	req := Request{Config: Config{Method: http.MethodGet, Url: ts.URL + "/list"}}
+	SetFixture(&Fixture{Mode: FixtureModeRecord, Dir: dir})
+
+	if err := req.DoEach(ctx, func(elem MSI) error {
+		return fmt.Errorf("stop")
+	}); err == nil || !strings.Contains(err.Error(), "stop") {
+		t.Fatalf("get err %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 { //提前返回，body不完整
+		t.Fatalf("recorded %d files", len(files))
+	}
+
+	var n int
+	if err := req.DoEach(ctx, func(elem MSI) error {
+		n++
+		return nil
+	}); err != nil || n != 1000 {
+		t.Fatalf("get %d err %v", n, err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("recorded %d files", len(files))
+	}
+	bs, _ := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	if !strings.Contains(string(bs), `{\"a\":999,`) {
+		t.Errorf("get incomplete body %d bytes", len(bs))
+	}
+}
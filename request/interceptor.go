@@ -0,0 +1,60 @@
+package request
+
+import (
+	"context"
+	"github.com/gosexy/to"
+	"net/http"
+)
+
+//拦截器链中传递的响应
+type Response struct {
+	*http.Response
+	Bytes    []byte    //响应body，Response.Body已被读完并关闭，所以从这里取；流式请求时为nil，Body由调用者读取
+	RespBody RespBodyI //状态码200时，已解析（并Check）的响应
+}
+
+//调用链的下一环
+type Invoker func(ctx context.Context, request *http.Request) (*Response, error)
+
+//类似http.RoundTripper，但能访问到解析后的响应
+//可以在调用next之前修改request（例如签名、透传header、注入故障），
+//也可以在之后处理response和err（例如打日志、统计）
+//不调用next则不会发出请求
+type Interceptor func(ctx context.Context, request *http.Request, next Invoker) (*Response, error)
+
+var interceptors []Interceptor //全局拦截器
+
+//注册全局拦截器，对所有请求生效，按注册顺序从外到内执行
+//NOTE: 非并发安全，只能用于初始化时候
+func Use(interceptor ...Interceptor) {
+	interceptors = append(interceptors, interceptor...)
+}
+
+//只对该接口生效的拦截器，在全局拦截器之后执行
+func (m Config) WithInterceptor(interceptor ...Interceptor) Config {
+	//复制一份，避免共用底层数组
+	m.interceptors = append(append([]Interceptor{}, m.interceptors...), interceptor...)
+	return m
+}
+
+//invoker是最内层的调用
+func (m Request) invoke(ctx context.Context, request *http.Request, invoker Invoker) (*Response, error) {
+	chain := append(append([]Interceptor{}, interceptors...), m.interceptors...)
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, next := chain[i], invoker
+		invoker = func(ctx context.Context, request *http.Request) (*Response, error) {
+			return interceptor(ctx, request, next)
+		}
+	}
+	return invoker(ctx, request)
+}
+
+//设置header的拦截器，例如透传header
+func HeaderInterceptor(header func(ctx context.Context) MSI) Interceptor {
+	return func(ctx context.Context, request *http.Request, next Invoker) (*Response, error) {
+		for k, v := range header(ctx) {
+			request.Header.Set(k, to.String(v))
+		}
+		return next(ctx, request)
+	}
+}
@@ -0,0 +1,63 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInterceptor(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ret":0,"msg":"%s"}`, r.Header.Get("Sign"))
+	}))
+	defer ts.Close()
+
+	var order []string
+	Use(func(ctx context.Context, request *http.Request, next Invoker) (*Response, error) {
+		order = append(order, "global")
+		request.Header.Set("Sign", "abc")
+		return next(ctx, request)
+	})
+	defer func() { interceptors = nil }()
+
+	req := Request{
+		Config: Config{
+			Method: http.MethodGet,
+			Url:    ts.URL,
+		}.WithInterceptor(func(ctx context.Context, request *http.Request, next Invoker) (*Response, error) {
+			order = append(order, "config")
+			resp, err := next(ctx, request)
+			if err != nil {
+				return resp, err
+			}
+			if msg := resp.RespBody.(*RespRet).Msg; msg != "abc" {
+				t.Errorf("get msg %s want abc", msg)
+			}
+			return resp, err
+		}),
+	}
+	var resp RespRet
+	if err := req.Do(ctx, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[global config]" {
+		t.Errorf("get order %v", order)
+	}
+}
+
+func TestInterceptorAbort(t *testing.T) {
+	req := Request{
+		Config: Config{
+			Method: http.MethodGet,
+			Url:    "http://localhost:1",
+		}.WithInterceptor(func(ctx context.Context, request *http.Request, next Invoker) (*Response, error) {
+			return nil, fmt.Errorf("fault injection")
+		}),
+	}
+	if err := req.Do(ctx, &RespEmpty{}); err == nil {
+		t.Fatal("want err")
+	}
+}
@@ -0,0 +1,130 @@
+package request
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+)
+
+//进程内的mock下游，用于测试时断言发出的请求
+type MockServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	routes []*MockRoute
+	calls  []MockCall
+}
+
+type MockRoute struct {
+	server     *MockServer //修改时加server的锁，处理请求时会读
+	method     string
+	path       string
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+//收到的一次请求
+type MockCall struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+//用完需要Close
+func NewMockServer() *MockServer {
+	m := &MockServer{}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+//未设置Reply时响应200和{"ret":0}
+func (m *MockServer) On(method, path string) *MockRoute {
+	route := &MockRoute{
+		server:     m,
+		method:     method,
+		path:       path,
+		statusCode: http.StatusOK,
+		header:     http.Header{"Content-Type": {"application/json"}},
+		body:       []byte(`{"ret":0}`),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, route)
+	return route
+}
+
+//body不是[]byte或string时，编码成json
+func (m *MockRoute) Reply(statusCode int, body interface{}) *MockRoute {
+	var bs []byte
+	switch body := body.(type) {
+	case []byte:
+		bs = body
+	case string:
+		bs = []byte(body)
+	default:
+		var err error
+		if bs, err = json.Marshal(body); err != nil {
+			panic(err)
+		}
+	}
+	m.server.mu.Lock()
+	defer m.server.mu.Unlock()
+	m.statusCode = statusCode
+	m.body = bs
+	return m
+}
+
+func (m *MockRoute) Header(k, v string) *MockRoute {
+	m.server.mu.Lock()
+	defer m.server.mu.Unlock()
+	m.header.Set(k, v)
+	return m
+}
+
+//返回method和path匹配的请求，method或path为空则不作为过滤条件
+func (m *MockServer) Calls(method, path string) (calls []MockCall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, call := range m.calls {
+		if (method == "" || method == call.Method) && (path == "" || path == call.Path) {
+			calls = append(calls, call)
+		}
+	}
+	return
+}
+
+func (m *MockServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	m.mu.Lock()
+	m.calls = append(m.calls, MockCall{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+		Body:   body,
+	})
+	var route *MockRoute
+	for i := len(m.routes) - 1; i >= 0; i-- { //后设置的优先
+		if m.routes[i].method == r.Method && m.routes[i].path == r.URL.Path {
+			route = m.routes[i]
+			break
+		}
+	}
+	if route == nil {
+		m.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	for k, vs := range route.header {
+		w.Header()[k] = append([]string(nil), vs...)
+	}
+	statusCode, body := route.statusCode, route.body
+	m.mu.Unlock()
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
@@ -0,0 +1,60 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestMockServer(t *testing.T) {
+	ts := NewMockServer()
+	defer ts.Close()
+	ts.On(http.MethodGet, "/book").Reply(http.StatusOK, `{"ret":0,"msg":"ok"}`)
+	ts.On(http.MethodGet, "/author").Reply(http.StatusBadGateway, nil)
+
+	req := Request{
+		Config: Config{Method: http.MethodGet, Url: ts.URL + "/book"},
+		Query:  MSI{"id": []int{1, 2}},
+	}
+	var resp RespRet
+	if err := req.Do(ctx, &resp); err != nil {
+		t.Fatal(err)
+	}
+	req.Url = ts.URL + "/author"
+	if err := req.Do(ctx, &resp); err == nil {
+		t.Error("want err")
+	}
+
+	calls := ts.Calls(http.MethodGet, "/book")
+	if len(calls) != 1 || len(calls[0].Query["id"]) != 2 {
+		t.Errorf("get calls %+v", calls)
+	}
+	if n := len(ts.Calls("", "")); n != 2 {
+		t.Errorf("get %d calls want 2", n)
+	}
+}
+
+//请求过程中修改响应
+func TestMockServerReplyConcurrent(t *testing.T) {
+	ts := NewMockServer()
+	defer ts.Close()
+	route := ts.On(http.MethodGet, "/book")
+	req := Request{Config: Config{Method: http.MethodGet, Url: ts.URL + "/book"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			route.Reply(http.StatusOK, MSI{"ret": 0, "msg": fmt.Sprint(i)}).Header("X-Version", fmt.Sprint(i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			var resp RespRet
+			if err := req.Do(ctx, &resp); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/gosexy/to"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context/ctxhttp"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -36,7 +35,11 @@ type Config struct {
 	Method string        `json:"method" validate:"oneof= GET POST PUT DELETE"`
 	Url    string        `json:"url" validate:"url"`
 	Client *ClientConfig `json:"client"`
-	query  MSI           //一些query公参，例如caller=projectName
+	//响应body的最大字节数，超过则返回BodyTooLargeError，0则不限制，只对Do生效，流式请求不限制
+	MaxBodySize int64 `json:"max_body_size"`
+	query       MSI   //一些query公参，例如caller=projectName
+
+	interceptors []Interceptor //只对该接口生效的拦截器，在全局拦截器之后执行
 }
 
 func (m Config) WithQuery(k string, v interface{}) Config {
@@ -214,42 +217,81 @@ func tryGetJson(header http.Header, b []byte) (resp interface{}) {
 
 func (m Request) Do(ctx context.Context, respBody RespBodyI) (err error) {
 	defer guard.BeforeCtx(&ctx)(&err)
-	entry := logrus.WithContext(ctx).WithField("m", m)
 
 	request, err := m.GetRequest(ctx)
 	if err != nil {
 		return
 	}
-	entry = entry.WithFields(logrus.Fields{
-		"request_url":    request.URL.String(),
-		"request_header": request.Header,
+	//以下发生的错误都是rpc错误
+	defer func() {
+		if err != nil {
+			if _, ok := err.(code.Code); !ok {
+				err = code.ServerErrRpc.WithError(err)
+			} //else已经被设置了错误码（在Check接口中），则不再设置
+		}
+	}()
+	_, err = m.invoke(ctx, request, func(ctx context.Context, request *http.Request) (*Response, error) {
+		return m.do(ctx, request, respBody)
 	})
+	return
+}
+
+//发送请求，拿到响应header就返回，由调用者读取并关闭Body
+func (m Request) send(ctx context.Context, request *http.Request, entry *logrus.Entry) (resp *http.Response, err error) {
 	client := defaultClient
 	if m.Client != nil {
 		client = m.Client.GetClient()
 	}
+	if fixture != nil {
+		client = fixture.wrap(client)
+		ctx = withFixtureUrl(ctx, request.URL.String())
+	}
 	if seg := xray.GetSegment(ctx); seg != nil { //允许不传xray的ctx
 		client = xray.Client(client)
 	}
-	//以下发生的错误都是rpc错误
-	defer func() {
-		if err != nil {
-			if _, ok := err.(code.Code); !ok {
-				err = code.ServerErrRpc.WithError(err)
-			} //else已经被设置了错误码（在Check接口中），则不再设置
+	done := func(error) {}
+	if fixture == nil || fixture.Mode != FixtureModeReplay { //回放时不需要真实地址
+		if request, done, err = resolve(ctx, request); err != nil {
+			entry.WithError(err).Error()
+			return
 		}
-	}()
-	resp, err := ctxhttp.Do(ctx, client, request)
+	}
+	resp, err = ctxhttp.Do(ctx, client, request)
 	if err != nil { //超时
+		done(err)
 		entry.WithError(err).Error()
 		return
 	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		done(fmt.Errorf("StatusCode %d", resp.StatusCode))
+	} else {
+		done(nil)
+	}
+	return
+}
+
+//拦截器链的最内层，真正发送请求并解析响应
+func (m Request) do(ctx context.Context, request *http.Request, respBody RespBodyI) (response *Response, err error) {
+	entry := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"m":              m,
+		"request_url":    request.URL.String(),
+		"request_header": request.Header,
+	})
+	resp, err := m.send(ctx, request, entry)
+	if err != nil {
+		return
+	}
 	defer resp.Body.Close()
-	respBodyBs, err := ioutil.ReadAll(resp.Body)
+	respBodyBs, err := m.readAll(resp.Body)
 	if err != nil {
 		entry.WithError(err).Error()
 		return
 	}
+	response = &Response{
+		Response: resp,
+		Bytes:    respBodyBs,
+		RespBody: respBody,
+	}
 
 	if logrus.IsLevelEnabled(logrus.DebugLevel) {
 		//影响性能，所以只有debug下才执行
@@ -272,7 +314,7 @@ func (m Request) Do(ctx context.Context, respBody RespBodyI) (err error) {
 			return
 		}
 		entry.Debug() //出错后会打err，因此不出错打debug
-		return nil
+		return response, nil
 	} else {
 		err = fmt.Errorf("StatusCode %d != 200", resp.StatusCode)
 		//err = code.ServerErrRpc.WithError(err)
@@ -0,0 +1,40 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+//服务发现，根据url中的host（服务名）解析出实际地址，
+//例如注册scheme为consul后，consul://service-name/path 会被解析成 http://ip:port/path
+type Resolver interface {
+	//返回host:port，done在请求结束后调用，传入rpc错误（超时、5xx），用于负载均衡和摘除故障实例
+	Resolve(ctx context.Context, service string) (host string, done func(err error), err error)
+}
+
+var resolvers = map[string]Resolver{}
+
+//NOTE: 非并发安全，只能用于初始化时候
+func RegisterResolver(scheme string, resolver Resolver) {
+	resolvers[scheme] = resolver
+}
+
+//如果scheme注册了Resolver，则返回替换了地址的请求，否则原样返回
+func resolve(ctx context.Context, request *http.Request) (*http.Request, func(err error), error) {
+	resolver, ok := resolvers[request.URL.Scheme]
+	if !ok {
+		return request, func(error) {}, nil
+	}
+	host, done, err := resolver.Resolve(ctx, request.URL.Host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve %s failed: %s", request.URL.Host, err)
+	}
+	u := *request.URL //复制一份，避免影响外层拦截器看到的url
+	u.Scheme = "http"
+	u.Host = host
+	request = request.WithContext(ctx)
+	request.URL = &u
+	request.Host = host
+	return request, done, nil
+}
@@ -0,0 +1,152 @@
+package request
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"zlutils/code"
+	"zlutils/guard"
+)
+
+//响应body超过Config.MaxBodySize
+type BodyTooLargeError struct {
+	MaxBodySize int64
+}
+
+func (e BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds max_body_size %d bytes", e.MaxBodySize)
+}
+
+func (m Request) readAll(body io.Reader) ([]byte, error) {
+	if m.MaxBodySize <= 0 {
+		return ioutil.ReadAll(body)
+	}
+	bs, err := ioutil.ReadAll(io.LimitReader(body, m.MaxBodySize+1)) //多读一个字节，用于判断是否超过
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bs)) > m.MaxBodySize {
+		return nil, BodyTooLargeError{MaxBodySize: m.MaxBodySize}
+	}
+	return bs, nil
+}
+
+//流式读取响应，适用于大文件导出、chunked接口等，body不会被读进内存
+//状态码不为200时不会调用handler，handler返回后body会被关闭
+func (m Request) DoStream(ctx context.Context, handler func(header http.Header, body io.Reader) error) (err error) {
+	defer guard.BeforeCtx(&ctx)(&err)
+
+	request, err := m.GetRequest(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			if _, ok := err.(code.Code); !ok {
+				err = code.ServerErrRpc.WithError(err)
+			}
+		}
+	}()
+	response, err := m.invoke(ctx, request, m.doStream)
+	if err != nil {
+		return
+	}
+	if response == nil || response.Response == nil || response.Body == nil { //拦截器或mock没有返回body
+		err = fmt.Errorf("stream response has no body")
+		logrus.WithContext(ctx).WithField("m", m).WithError(err).Error()
+		return
+	}
+	defer response.Body.Close()
+	if err = handler(response.Header, response.Body); err != nil {
+		logrus.WithContext(ctx).WithField("m", m).WithError(err).Error()
+		return
+	}
+	return
+}
+
+//拦截器链的最内层，只读取header
+func (m Request) doStream(ctx context.Context, request *http.Request) (response *Response, err error) {
+	entry := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"m":              m,
+		"request_url":    request.URL.String(),
+		"request_header": request.Header,
+	})
+	resp, err := m.send(ctx, request, entry)
+	if err != nil {
+		return
+	}
+	entry = entry.WithFields(logrus.Fields{
+		"response_header": resp.Header,
+		"StatusCode":      resp.StatusCode,
+	})
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096)) //只为了打日志，不全读
+		err = fmt.Errorf("StatusCode %d != 200", resp.StatusCode)
+		entry.WithField("response_body", tryGetJson(resp.Header, bs)).WithError(err).Error()
+		return
+	}
+	entry.Debug()
+	return &Response{Response: resp}, nil
+}
+
+//逐个解析响应中的元素，支持json数组和NDJSON（每行一个json）
+//fn必须是func(elem T) error，T为元素类型，fn返回err则停止解析并返回该err
+func (m Request) DoEach(ctx context.Context, fn interface{}) (err error) {
+	fnValue := reflect.ValueOf(fn)
+	fnType := reflect.TypeOf(fn)
+	if fnType == nil || fnType.Kind() != reflect.Func || fnValue.IsNil() || fnType.NumIn() != 1 || fnType.NumOut() != 1 ||
+		!fnType.Out(0).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		err = fmt.Errorf("fn type %v must be non-nil func(elem T) error", fnType)
+		logrus.WithContext(ctx).WithError(err).Error()
+		return
+	}
+	elemType := fnType.In(0)
+	return m.DoStream(ctx, func(header http.Header, body io.Reader) error {
+		reader := bufio.NewReader(body)
+		decoder := json.NewDecoder(reader)
+		if isArray, err := startsWithArray(reader); err != nil {
+			return err
+		} else if isArray {
+			if _, err := decoder.Token(); err != nil { //读掉[
+				return err
+			}
+		}
+		for decoder.More() {
+			elemPtr := reflect.New(elemType)
+			if err := decoder.Decode(elemPtr.Interface()); err != nil {
+				return err
+			}
+			if out := fnValue.Call([]reflect.Value{elemPtr.Elem()}); !out[0].IsNil() {
+				return out[0].Interface().(error)
+			}
+		}
+		_, err := io.Copy(ioutil.Discard, reader) //数组在]处结束，读完剩下的，录制时才会保存
+		return err
+	})
+}
+
+//跳过空白，判断第一个字符是否是[
+func startsWithArray(reader *bufio.Reader) (bool, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			reader.ReadByte()
+		default:
+			return b[0] == '[', nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoEach(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/array":
+			fmt.Fprint(w, ` [{"a":1},{"a":2},{"a":3}]`)
+		case "/ndjson":
+			fmt.Fprint(w, "{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n")
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/array", "/ndjson"} {
+		req := Request{Config: Config{Method: http.MethodGet, Url: ts.URL + path}}
+		sum := 0
+		if err := req.DoEach(ctx, func(elem struct{ A int }) error {
+			sum += elem.A
+			return nil
+		}); err != nil {
+			t.Fatal(path, err)
+		}
+		if sum != 6 {
+			t.Errorf("%s get sum %d want 6", path, sum)
+		}
+	}
+}
+
+func TestDoEachInvalidFn(t *testing.T) {
+	req := Request{Config: Config{Method: http.MethodGet, Url: "http://127.0.0.1:1"}}
+	var nilFn func(elem int) error
+	for _, fn := range []interface{}{nil, 1, nilFn, func(elem int) {}, func(a, b int) error { return nil }} {
+		if err := req.DoEach(ctx, fn); err == nil || !strings.Contains(err.Error(), "must be non-nil func") {
+			t.Errorf("%T get err %v", fn, err)
+		}
+	}
+}
+
+func TestDoStream(t *testing.T) {
+	body := strings.Repeat("a", 1<<16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	req := Request{Config: Config{Method: http.MethodGet, Url: ts.URL}}
+	if err := req.DoStream(ctx, func(header http.Header, r io.Reader) error {
+		bs, err := ioutil.ReadAll(r)
+		if len(bs) != len(body) {
+			t.Errorf("get len %d want %d", len(bs), len(body))
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req.MaxBodySize = 1024
+	err := req.Do(ctx, &RespEmpty{})
+	if err == nil || !strings.Contains(err.Error(), "max_body_size") {
+		t.Fatalf("get err %v want BodyTooLargeError", err)
+	}
+}
+
+func TestDoStreamNoBody(t *testing.T) {
+	for _, resp := range []*Response{nil, {}, {Response: &http.Response{}}} {
+		req := Request{Config: Config{Method: http.MethodGet, Url: "http://localhost"}.
+			WithInterceptor(func(ctx context.Context, request *http.Request, next Invoker) (*Response, error) {
+				return resp, nil
+			})}
+		if err := req.DoStream(ctx, func(header http.Header, r io.Reader) error {
+			t.Error("handler called")
+			return nil
+		}); err == nil {
+			t.Errorf("%v want err", resp)
+		}
+	}
+}